@@ -0,0 +1,247 @@
+package streammux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"syscall"
+)
+
+var errNoArrays = errors.New("no array members found")
+
+// missingDevice stands in for a member that was not found during assembly.
+type missingDevice struct{}
+
+func (missingDevice) Open() State               { return FAILED }
+func (missingDevice) Read([]byte) (int, error)  { return 0, syscall.EIO }
+func (missingDevice) Write([]byte) (int, error) { return 0, syscall.EIO }
+func (missingDevice) Close() error              { return nil }
+
+// replayDevice returns the superblock consumed during assembly on the first
+// reads from a device that cannot seek.
+type replayDevice struct {
+	io.ReadWriteCloser
+	pending []byte
+}
+
+func (d *replayDevice) Read(p []byte) (n int, err error) {
+	if len(d.pending) > 0 {
+		n = copy(p, d.pending)
+		d.pending = d.pending[n:]
+
+		return n, nil
+	}
+
+	return d.ReadWriteCloser.Read(p)
+}
+
+func (d *replayDevice) Open() State {
+	if opener, ok := d.ReadWriteCloser.(Opener); ok {
+		return opener.Open()
+	}
+
+	return OK
+}
+
+// rewind returns rwc positioned at the start of its superblock.
+func rewind(rwc io.ReadWriteCloser, sb *superblock) (io.ReadWriteCloser, error) {
+	if seeker, ok := rwc.(io.Seeker); ok {
+		_, err := seeker.Seek(0, io.SeekStart)
+		return rwc, err
+	}
+
+	return &replayDevice{rwc, sb.marshal()}, nil
+}
+
+// found is a device identified during assembly.
+type found struct {
+	rwc io.ReadWriteCloser
+	sb  *superblock
+}
+
+// Assemble identifies the members of one or more arrays from an unordered set
+// of devices by their superblocks. Devices are grouped by array UUID, ordered
+// by member index and the behavior the array was created with is returned,
+// already opened. Members that were not found are reported as FAILED, making
+// the array DEGRADED if it has enough redundancy. Devices without a valid
+// superblock are ignored.
+//
+// Devices must be positioned at the start of their superblock. Devices that
+// implement io.Seeker are rewound after the superblock has been read. The
+// options are applied to the members of every array, in addition to the
+// geometry recorded in the superblocks. An error is returned if the
+// superblocks of an array describe a geometry no behavior can be built with.
+func Assemble(rwcs []io.ReadWriteCloser, opts ...MemberOption) ([]Array, error) {
+	groups := make(map[UUID][]found)
+
+	for _, rwc := range rwcs {
+		sb, err := readSuperblock(rwc)
+		if err != nil {
+			continue
+		}
+
+		if rwc, err = rewind(rwc, sb); err != nil {
+			return nil, err
+		}
+
+		groups[sb.uuid] = append(groups[sb.uuid], found{rwc, sb})
+	}
+
+	if len(groups) == 0 {
+		return nil, errNoArrays
+	}
+
+	uuids := make([]UUID, 0, len(groups))
+	for uuid := range groups {
+		uuids = append(uuids, uuid)
+	}
+
+	sort.Slice(uuids, func(i, j int) bool {
+		return bytes.Compare(uuids[i][:], uuids[j][:]) < 0
+	})
+
+	arrays := make([]Array, 0, len(groups))
+	for _, uuid := range uuids {
+		a, err := assemble(groups[uuid], opts)
+		if err != nil {
+			return nil, fmt.Errorf("assembling array %v: %w", uuid, err)
+		}
+
+		arrays = append(arrays, a)
+	}
+
+	for _, a := range arrays {
+		a.Open()
+	}
+
+	return arrays, nil
+}
+
+// minMembers is the smallest number of members of an array of each kind.
+var minMembers = map[Kind]int{
+	KindStripe:            1,
+	KindMirror:            1,
+	KindDedicatedParity:   2,
+	KindDistributedParity: 2,
+	KindDualParity:        3,
+	KindErasureCoded:      1,
+	KindConcat:            1,
+}
+
+// assemble builds the array described by devs, which all share an UUID. It
+// returns an error if the superblocks describe an invalid geometry.
+func assemble(devs []found, extra []MemberOption) (Array, error) {
+	// the newest superblock describes the array
+	ref := devs[0].sb
+	for _, dev := range devs {
+		if dev.sb.generation > ref.generation {
+			ref = dev.sb
+		}
+	}
+
+	least, ok := minMembers[ref.kind]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown kind %d", errGeometry, ref.kind)
+	}
+
+	if ref.members < least || ref.data < 1 || ref.data > ref.members {
+		return nil, fmt.Errorf("%w: %d members with %d data members", errGeometry, ref.members, ref.data)
+	}
+
+	// order the devices by member index and segment, keeping only the newest
+	// device for each position
+	segments := make([][]io.ReadWriteCloser, ref.members)
+	generations := make([][]uint64, ref.members)
+
+	for _, dev := range devs {
+		sb := dev.sb
+		if sb.index >= ref.members {
+			continue
+		}
+
+		for len(segments[sb.index]) <= sb.segment {
+			segments[sb.index] = append(segments[sb.index], nil)
+			generations[sb.index] = append(generations[sb.index], 0)
+		}
+
+		if segments[sb.index][sb.segment] == nil || sb.generation > generations[sb.index][sb.segment] {
+			segments[sb.index][sb.segment] = dev.rwc
+			generations[sb.index][sb.segment] = sb.generation
+		}
+	}
+
+	rwcs := make([]io.ReadWriteCloser, ref.members)
+	for i, segs := range segments {
+		if len(segs) == 0 || segs[0] == nil {
+			rwcs[i] = missingDevice{}
+			continue
+		}
+
+		rwcs[i] = segs[0]
+	}
+
+	// behaviors that do not stripe record no stripe unit and keep the default
+	var opts []MemberOption
+	if ref.unit > 0 {
+		opts = append(opts, WithStripeUnit(ref.unit))
+	}
+
+	if ref.flags&flagChecksums != 0 {
+		opts = append(opts, WithChecksums())
+	}
+
+	opts = append(opts, extra...)
+
+	var a Array
+	var err error
+
+	switch ref.kind {
+	case KindStripe:
+		a = NewStripe(rwcs, opts...)
+	case KindMirror:
+		a = NewMirrorWithOptions(rwcs, opts...)
+	case KindDedicatedParity:
+		k := ref.members - 1
+		a = NewDedicatedParity(rwcs[k], rwcs[:k], opts...)
+	case KindDistributedParity:
+		a = NewDistributedParity(rwcs, opts...)
+	case KindDualParity:
+		k := ref.members - 2
+		a, err = NewDualParity(rwcs[k], rwcs[k+1], rwcs[:k], opts...)
+	case KindErasureCoded:
+		a, err = NewErasureCoded(rwcs[:ref.data], rwcs[ref.data:], opts...)
+	case KindConcat:
+		a = NewConcat(rwcs, opts...)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	members := a.Members()
+
+	// adopt the identity of the assembled array
+	members[0].array.adopt(ref)
+
+	// continue members on the spare segments that were found
+	for i, segs := range segments {
+		if len(segs) == 0 {
+			continue
+		}
+
+		for _, seg := range segs[1:] {
+			if seg == nil {
+				break
+			}
+
+			members[i].segments = append(members[i].segments, &segment{
+				rwc:  seg,
+				upto: -1,
+			})
+		}
+	}
+
+	return a, nil
+}
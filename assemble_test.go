@@ -0,0 +1,207 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	mrand "math/rand"
+	"testing"
+	"time"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+func TestAssemble(t *testing.T) {
+	blkdevs := make([]*testutil.BlockDevice, 5)
+	for i := range blkdevs {
+		blkdevs[i] = testutil.NewBlockDevice(1 << 20)
+	}
+
+	striped := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	data := make([]byte, 1<<19)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp := newParityArray(blkdevs)
+	dp.Open()
+	writeArray(t, dp, data)
+
+	s := streammux.NewStripe(striped)
+	s.Open()
+	writeArray(t, s, make([]byte, 1<<19))
+
+	// an unordered set of devices, including one without a superblock
+	rwcs := append([]io.ReadWriteCloser{testutil.NewBlockDevice(1 << 20)}, striped...)
+	for _, blkdev := range blkdevs {
+		rwcs = append(rwcs, blkdev)
+	}
+
+	mrand.Shuffle(len(rwcs), func(i, j int) {
+		rwcs[i], rwcs[j] = rwcs[j], rwcs[i]
+	})
+
+	arrays, err := streammux.Assemble(rwcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(arrays) != 2 {
+		t.Fatalf("expected 2 arrays, got %d", len(arrays))
+	}
+
+	for _, a := range arrays {
+		if a.Health() != streammux.OK {
+			t.Fatal("expected assembled array to be OK")
+		}
+
+		got, err := io.ReadAll(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch a.(type) {
+		case *streammux.DedicatedParity:
+			if !bytes.Equal(data, got) {
+				t.Fatal("data read from assembled array differs from the data written")
+			}
+		case *streammux.Stripe:
+			if len(got) != 1<<19 {
+				t.Fatalf("expected %d bytes, got %d", 1<<19, len(got))
+			}
+		default:
+			t.Fatalf("unexpected array type %T", a)
+		}
+
+		a.Close()
+	}
+}
+
+func TestAssembleMissingMember(t *testing.T) {
+	blkdevs := make([]*testutil.BlockDevice, 5)
+	for i := range blkdevs {
+		blkdevs[i] = testutil.NewBlockDevice(1 << 20)
+	}
+
+	data := make([]byte, 1<<19)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp := newParityArray(blkdevs)
+	dp.Open()
+	writeArray(t, dp, data)
+
+	// leave out a data member
+	rwcs := []io.ReadWriteCloser{blkdevs[4], blkdevs[0], blkdevs[3], blkdevs[1]}
+
+	arrays, err := streammux.Assemble(rwcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(arrays) != 1 {
+		t.Fatalf("expected 1 array, got %d", len(arrays))
+	}
+
+	a := arrays[0]
+	defer a.Close()
+
+	if a.Health() != streammux.DEGRADED {
+		t.Fatal("expected the array to be DEGRADED")
+	}
+
+	got, err := io.ReadAll(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read from degraded array differs from the data written")
+	}
+}
+
+func TestAssembleNoArrays(t *testing.T) {
+	rwcs := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	if _, err := streammux.Assemble(rwcs); err == nil {
+		t.Fatal("expected an error when no array members are found")
+	}
+}
+
+func TestAssembleInvalidGeometry(t *testing.T) {
+	dev := testutil.NewBlockDevice(1 << 20)
+
+	m := streammux.NewMirror(dev)
+	m.Open()
+	writeArray(t, m, []byte("data"))
+
+	sb := make([]byte, streammux.SuperblockSize)
+	if _, err := io.ReadFull(dev, sb); err != nil {
+		t.Fatal(err)
+	}
+
+	// relabel the single member as a DualParity, which needs at least three
+	sb[24] = byte(streammux.KindDualParity)
+	binary.LittleEndian.PutUint32(sb[60:], crc32.Checksum(sb[:60], crc32.MakeTable(crc32.Castagnoli)))
+	restore(t, dev, sb)
+
+	if _, err := streammux.Assemble([]io.ReadWriteCloser{dev}); err == nil {
+		t.Fatal("expected an error for an array too small for its kind")
+	}
+}
+
+func TestAssembleMirrorReadAhead(t *testing.T) {
+	rwcs := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	data := make([]byte, 1<<18)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m := streammux.NewMirror(rwcs...)
+	m.Open()
+	writeArray(t, m, data)
+
+	// a mirror records no stripe unit, which must not leave the blocks read
+	// ahead empty
+	arrays, err := streammux.Assemble(rwcs, streammux.WithReadAhead(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(arrays) != 1 {
+		t.Fatalf("expected 1 array, got %d", len(arrays))
+	}
+
+	a := arrays[0]
+	defer a.Close()
+
+	done := make(chan []byte, 1)
+
+	go func() {
+		got, _ := io.ReadAll(a)
+		done <- got
+	}()
+
+	select {
+	case got := <-done:
+		if !bytes.Equal(data, got) {
+			t.Fatal("data read from assembled array differs from the data written")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the read of the assembled mirror to complete")
+	}
+}
@@ -0,0 +1,128 @@
+package streammux_test
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+// discard is a device that accepts any amount of data and drops it.
+type discard struct{}
+
+func (discard) Read([]byte) (int, error)    { return 0, io.EOF }
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+func (discard) Close() error                { return nil }
+
+func discards(n int) []io.ReadWriteCloser {
+	rwcs := make([]io.ReadWriteCloser, n)
+	for i := range rwcs {
+		rwcs[i] = discard{}
+	}
+
+	return rwcs
+}
+
+func blockDevices(n int) []io.ReadWriteCloser {
+	rwcs := make([]io.ReadWriteCloser, n)
+	for i := range rwcs {
+		rwcs[i] = testutil.NewBlockDevice(1 << 23)
+	}
+
+	return rwcs
+}
+
+var benchSizes = []int{8, 512, 4096, 1 << 20}
+
+func benchmarkWrite(b *testing.B, newArray func([]io.ReadWriteCloser) streammux.Array, members int) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			a := newArray(discards(members))
+			p := make([]byte, size)
+
+			a.Open()
+			defer a.Close()
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := a.Write(p); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func benchmarkRead(b *testing.B, newArray func([]io.ReadWriteCloser) streammux.Array, members int) {
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			a := newArray(blockDevices(members))
+
+			a.Open()
+			writeArray(b, a, make([]byte, 1<<22))
+
+			p := make([]byte, size)
+
+			// the superblocks are read by the first read of a stream, which
+			// is kept out of the measurement
+			a.Open()
+			defer a.Close()
+
+			if _, err := a.Read(p); err != nil {
+				b.Fatal(err)
+			}
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				_, err := a.Read(p)
+				if err == io.EOF {
+					// start over at the end of the stream
+					b.StopTimer()
+					a.Close()
+					a.Open()
+					_, err = a.Read(p)
+					b.StartTimer()
+				}
+
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func newBenchMirror(rwcs []io.ReadWriteCloser) streammux.Array {
+	return streammux.NewMirror(rwcs...)
+}
+
+func newBenchStripe(rwcs []io.ReadWriteCloser) streammux.Array {
+	return streammux.NewStripe(rwcs, streammux.WithStripeUnit(512))
+}
+
+func newBenchDedicatedParity(rwcs []io.ReadWriteCloser) streammux.Array {
+	return streammux.NewDedicatedParity(rwcs[0], rwcs[1:], streammux.WithStripeUnit(512))
+}
+
+func newBenchDedicatedParityReadAhead(rwcs []io.ReadWriteCloser) streammux.Array {
+	return streammux.NewDedicatedParity(rwcs[0], rwcs[1:], streammux.WithStripeUnit(512), streammux.WithReadAhead(8))
+}
+
+func BenchmarkMirrorWrite(b *testing.B)          { benchmarkWrite(b, newBenchMirror, 2) }
+func BenchmarkMirrorRead(b *testing.B)           { benchmarkRead(b, newBenchMirror, 2) }
+func BenchmarkStripeWrite(b *testing.B)          { benchmarkWrite(b, newBenchStripe, 4) }
+func BenchmarkStripeRead(b *testing.B)           { benchmarkRead(b, newBenchStripe, 4) }
+func BenchmarkDedicatedParityWrite(b *testing.B) { benchmarkWrite(b, newBenchDedicatedParity, 4) }
+func BenchmarkDedicatedParityRead(b *testing.B)  { benchmarkRead(b, newBenchDedicatedParity, 4) }
+
+func BenchmarkDedicatedParityReadAhead(b *testing.B) {
+	benchmarkRead(b, newBenchDedicatedParityReadAhead, 4)
+}
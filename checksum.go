@@ -0,0 +1,141 @@
+package streammux
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+// recordHeaderSize is the size of the header preceding every checksummed
+// record. It holds the length of the record and the CRC32C of the length and
+// the data.
+const recordHeaderSize = 8
+
+// maxRecordSize is the largest record written. Larger writes are split into
+// several records and a header claiming more is considered corrupt.
+const maxRecordSize = 16 << 20
+
+func recordChecksum(hdr, data []byte) uint32 {
+	crc := crc32.Update(0, castagnoli, hdr[0:4])
+	return crc32.Update(crc, castagnoli, data)
+}
+
+// writeRecords writes p as one or more checksummed records and returns the
+// number of bytes of p written.
+func (m *Member) writeRecords(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		size := len(p)
+		if size > maxRecordSize {
+			size = maxRecordSize
+		}
+
+		rec := make([]byte, recordHeaderSize+size)
+		binary.LittleEndian.PutUint32(rec[0:], uint32(size))
+		copy(rec[recordHeaderSize:], p[:size])
+		binary.LittleEndian.PutUint32(rec[4:], recordChecksum(rec, p[:size]))
+
+		k, err := m.writeRaw(rec)
+
+		if k -= recordHeaderSize; k > 0 {
+			n += k
+		}
+
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+
+		p = p[size:]
+	}
+
+	return n, nil
+}
+
+// readRecord returns data from the current record, reading and verifying the
+// next record when the current one has been consumed. A record that fails
+// verification is skipped and ErrChecksum is returned.
+func (m *Member) readRecord(p []byte) (n int, err error) {
+	if m.roff == len(m.rbuf) {
+		if err := m.fillRecord(); err != nil {
+			return 0, err
+		}
+	}
+
+	n = copy(p, m.rbuf[m.roff:])
+	m.roff += n
+
+	return n, nil
+}
+
+func (m *Member) fillRecord() error {
+	m.rbuf = m.rbuf[:0]
+	m.roff = 0
+
+	var hdr [recordHeaderSize]byte
+
+	n, err := m.readFull(hdr[:])
+	if n == 0 && err == io.EOF {
+		return io.EOF
+	}
+
+	if err == io.EOF {
+		// a truncated header
+		return ErrChecksum
+	}
+
+	if err != nil {
+		return err
+	}
+
+	size := int(binary.LittleEndian.Uint32(hdr[0:]))
+	if size > maxRecordSize {
+		return ErrChecksum
+	}
+
+	if cap(m.rbuf) < size {
+		m.rbuf = make([]byte, 0, size)
+	}
+
+	data := m.rbuf[:size]
+
+	if _, err := m.readFull(data); err != nil {
+		if err == io.EOF {
+			return ErrChecksum
+		}
+
+		return err
+	}
+
+	if binary.LittleEndian.Uint32(hdr[4:]) != recordChecksum(hdr[:], data) {
+		return ErrChecksum
+	}
+
+	m.rbuf = data
+
+	return nil
+}
+
+// readFull reads exactly len(p) bytes, returning io.EOF if the member ends
+// early.
+func (m *Member) readFull(p []byte) (n int, err error) {
+	for n < len(p) {
+		var k int
+
+		k, err = m.readRaw(p[n:])
+		n += k
+
+		if err != nil {
+			break
+		}
+
+		if k == 0 {
+			err = io.ErrNoProgress
+			break
+		}
+	}
+
+	if n == len(p) && err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
@@ -0,0 +1,168 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+// corrupt flips a bit at each of the given offsets of a closed block device.
+func corrupt(t *testing.T, blkdev *testutil.BlockDevice, offsets ...int) {
+	buf := snapshot(t, blkdev)
+
+	for _, off := range offsets {
+		buf[off] ^= 0x10
+	}
+
+	restore(t, blkdev, buf)
+}
+
+func TestMirrorChecksums(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{blkdevs[0], blkdevs[1]},
+		streammux.WithChecksums(),
+	)
+
+	data := make([]byte, 1<<19)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+
+	for p := data; len(p) > 0; p = p[4096:] {
+		if _, err := m.Write(p[:4096]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip bits in the first and a later record of the first copy
+	corrupt(t, blkdevs[0], streammux.SuperblockSize+100, streammux.SuperblockSize+10*(4096+8)+8)
+
+	m.Open()
+
+	got := make([]byte, 0, len(data))
+	p := make([]byte, 4096)
+
+	for {
+		n, err := m.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, p[:n]...)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("corrupt copy was returned")
+	}
+
+	if m.Health() != streammux.OK {
+		t.Fatal("expected a corrupt copy to leave the mirror OK")
+	}
+
+	if n := m.Members()[0].Corruptions(); n != 2 {
+		t.Fatalf("expected 2 corruptions on the first member, got %d", n)
+	}
+
+	if n := m.Members()[1].Corruptions(); n != 0 {
+		t.Fatalf("expected no corruptions on the second member, got %d", n)
+	}
+
+	m.Close()
+}
+
+func TestDedicatedParityChecksums(t *testing.T) {
+	blkdevs := make([]*testutil.BlockDevice, 5)
+	rwcs := make([]io.ReadWriteCloser, len(blkdevs))
+
+	for i := range blkdevs {
+		blkdevs[i] = testutil.NewBlockDevice(1 << 20)
+		rwcs[i] = blkdevs[i]
+	}
+
+	dp := streammux.NewDedicatedParity(rwcs[0], rwcs[1:],
+		streammux.WithStripeUnit(512),
+		streammux.WithChecksums(),
+	)
+
+	data := make([]byte, 1<<19)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	writeArray(t, dp, data)
+
+	// flip a bit in the second chunk of the third data member
+	corrupt(t, blkdevs[3], streammux.SuperblockSize+(512+8)+8+42)
+
+	dp.Open()
+
+	got, err := io.ReadAll(dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("corrupt chunk was not reconstructed")
+	}
+
+	if dp.Health() != streammux.OK {
+		t.Fatal("expected a corrupt chunk to leave the array OK")
+	}
+
+	for i, member := range dp.Members() {
+		want := uint64(0)
+		if i == 2 {
+			want = 1
+		}
+
+		if n := member.Corruptions(); n != want {
+			t.Fatalf("expected %d corruptions on member %d, got %d", want, i, n)
+		}
+	}
+
+	dp.Close()
+}
+
+func TestStripeChecksums(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	s := streammux.NewStripe([]io.ReadWriteCloser{blkdevs[0], blkdevs[1]},
+		streammux.WithStripeUnit(512),
+		streammux.WithChecksums(),
+	)
+
+	s.Open()
+	writeArray(t, s, make([]byte, 1<<19))
+
+	corrupt(t, blkdevs[1], streammux.SuperblockSize+8+42)
+
+	s.Open()
+	defer s.Close()
+
+	// without redundancy the corruption can only be reported
+	if _, err := io.ReadAll(s); err == nil {
+		t.Fatal("expected reading a corrupt chunk to fail")
+	}
+}
@@ -0,0 +1,204 @@
+package streammux
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+)
+
+// Concat is a behavior that concatenates its members into one logical stream
+// (JBOD/span). Writes fill the first member until it returns ENOSPC or EOF and
+// then move on to the next member. Reads replay the same boundaries by
+// reading each member until EOF.
+type Concat struct {
+	sync.Mutex
+
+	ios []*Member
+
+	// index of the current member
+	cur int
+
+	// the array identity shared by the members
+	array *array
+
+	// the reads in flight on the current member and the size of a read
+	// issued ahead
+	ahead *readahead
+	unit  int
+
+	state health
+}
+
+// NewConcat returns a Concat of rwcs. Media holding a concatenation of the
+// same members is adopted when opened and blank media is formatted.
+func NewConcat(rwcs []io.ReadWriteCloser, opts ...MemberOption) *Concat {
+	c := &Concat{
+		ios: make([]*Member, len(rwcs)),
+	}
+
+	for i, rwc := range rwcs {
+		c.ios[i] = NewMember(rwc, opts...)
+
+		// a full member hands the stream on to the next one rather than
+		// failing or moving on to a spare
+		c.ios[i].opts.fills = true
+	}
+
+	c.array = attach(c, KindConcat, len(rwcs), 0, c.ios)
+
+	o := newMemberOptions(opts)
+	c.ahead = newReadahead(o.readAhead, 1, c.issueRead, c.completeRead)
+	c.unit = o.stripeUnit
+
+	return c
+}
+
+func (c *Concat) Health() State {
+	return c.state.get()
+}
+
+// Members returns the members of the behavior.
+func (c *Concat) Members() []*Member {
+	return c.ios
+}
+
+func (c *Concat) Open() State {
+	c.Lock()
+
+	c.cur = 0
+
+	// reset state
+	c.state.set(OK)
+
+	for _, rwc := range c.ios {
+		state := rwc.Open()
+		switch state {
+		case FAILED:
+			// there is no redundancy, so we're done for
+			c.state.set(FAILED)
+		case DEGRADED:
+			if c.state.get() == FAILED {
+				break
+			}
+
+			c.state.set(DEGRADED)
+		}
+	}
+
+	c.array.observe()
+
+	return c.state.get()
+}
+
+func (c *Concat) Close() (err error) {
+	defer c.Unlock()
+
+	c.ahead.reset()
+
+	for _, closer := range c.ios {
+		err = closer.Close()
+	}
+
+	return
+}
+
+// full reports whether err from a write indicates that the device is out of
+// space rather than broken. The members of a Concat report a full device as
+// io.EOF.
+func full(err error) bool {
+	return err == io.EOF || errors.Is(err, syscall.ENOSPC)
+}
+
+func (c *Concat) Read(p []byte) (n int, err error) {
+	defer c.array.observe()
+
+	if c.state.get() == FAILED {
+		return 0, ErrArrayFailed
+	}
+
+	for n < len(p) {
+		if c.cur == len(c.ios) {
+			break
+		}
+
+		k, err := c.ahead.stream(p[n:], c.unit)
+		n += k
+
+		if err == io.EOF {
+			// continue on the next member
+			c.cur++
+			continue
+		}
+
+		if err != nil {
+			c.state.set(FAILED)
+			return n, err
+		}
+	}
+
+	if c.state.get() == OK && anyDegraded(c.ios) {
+		c.state.set(DEGRADED)
+	}
+
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
+
+// issueRead submits the read of r.p to the current member.
+func (c *Concat) issueRead(r *inflight) error {
+	r.fetch(c.ios[c.cur], c.cur, r.p)
+
+	return nil
+}
+
+// completeRead collects the result of a read issued by issueRead.
+func (c *Concat) completeRead(r *inflight) (int, error) {
+	rc := <-r.results
+
+	return rc.n, rc.err
+}
+
+func (c *Concat) Write(p []byte) (n int, err error) {
+	defer c.array.observe()
+
+	if c.state.get() == FAILED {
+		return 0, ErrArrayFailed
+	}
+
+	ch := make(chan rwT, 1)
+
+	for n < len(p) {
+		if c.cur == len(c.ios) {
+			return n, syscall.ENOSPC
+		}
+
+		writer := c.ios[c.cur]
+
+		writer.write(c.cur, p[n:], ch)
+		rc := <-ch
+
+		n += rc.n
+
+		if rc.err == io.EOF {
+			// a full member is not broken, it just cannot hold any more, so
+			// continue on the next member
+			c.cur++
+			continue
+		}
+
+		if rc.err != nil {
+			c.state.set(FAILED)
+			return n, rc.err
+		}
+	}
+
+	if c.state.get() == OK && anyDegraded(c.ios) {
+		c.state.set(DEGRADED)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,153 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+func TestConcat(t *testing.T) {
+	blkdevs := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 19),
+		testutil.NewBlockDevice(1 << 21),
+	}
+
+	c := streammux.NewConcat(blkdevs)
+
+	c.Open()
+
+	data := make([]byte, 3000000)
+
+	f, err := os.Open("/dev/urandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.Read(data)
+	if err != nil || n != len(data) {
+		t.Fatal(err)
+	}
+
+	origSha256Sum := sha256.Sum256(data)
+
+	buf := bytes.NewBuffer(data)
+
+	// not a divisor of the member sizes, so writes straddle the boundaries
+	p := make([]byte, 1000)
+
+	for {
+		_, err := buf.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := c.Write(p)
+		if err != nil || n != len(p) {
+			t.Fatal(err, n)
+		}
+	}
+
+	// close to reset position
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen
+	if c.Open() != streammux.OK {
+		t.Fatal("expected full members to be OK")
+	}
+
+	buf.Reset()
+
+	for {
+		_, err := c.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = buf.Write(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newSha256Sum := sha256.Sum256(buf.Bytes())
+
+	if origSha256Sum != newSha256Sum {
+		t.Fatal("origSha256Sum != newSha256Sum")
+	}
+}
+
+func TestConcatNoSpace(t *testing.T) {
+	c := streammux.NewConcat([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1000),
+		testutil.NewBlockDevice(1000),
+	})
+
+	c.Open()
+	defer c.Close()
+
+	n, err := c.Write(make([]byte, 3000))
+	if err != syscall.ENOSPC {
+		t.Fatal("expected ENOSPC, got", err)
+	}
+
+	// each member starts with a superblock
+	if n != 2000-2*streammux.SuperblockSize {
+		t.Fatal("expected both members to be filled, wrote", n)
+	}
+}
+
+func TestConcatFullMember(t *testing.T) {
+	bus := streammux.NewEventBus()
+
+	sub := bus.Subscribe(16)
+	defer sub.Close()
+
+	sparePool := streammux.NewSparePool([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+	})
+
+	c := streammux.NewConcat([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 12),
+		testutil.NewBlockDevice(1 << 20),
+	}, streammux.WithSparePool(sparePool), streammux.WithEvents(bus))
+
+	c.Open()
+	defer c.Close()
+
+	// the first member fills, which moves the writes on to the next member
+	// rather than on to a spare
+	if n, err := c.Write(make([]byte, 1<<13)); err != nil || n != 1<<13 {
+		t.Fatal(err, n)
+	}
+
+	if c.Health() != streammux.OK || c.Members()[0].State() != streammux.OK {
+		t.Fatal("expected the full member and the concat to remain OK")
+	}
+
+	if sparePool.Available() != 1 {
+		t.Fatal("expected the spare to remain in the pool")
+	}
+
+	select {
+	case ev := <-sub.C:
+		t.Fatalf("expected no events, got %q", ev.Type)
+	default:
+	}
+}
@@ -0,0 +1,274 @@
+package streammux
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// WithTimeout fails a member whose device does not complete a read or write
+// within d, such that a hung device does not stall the array. Behaviors with
+// redundancy continue without the member.
+func WithTimeout(d time.Duration) MemberOption {
+	return func(o *memberOptions) {
+		o.timeout = d
+	}
+}
+
+// contextReader and contextWriter are implemented by devices, such as nested
+// behaviors, that accept a context for their I/O.
+type contextReader interface {
+	ReadContext(ctx context.Context, p []byte) (int, error)
+}
+
+type contextWriter interface {
+	WriteContext(ctx context.Context, p []byte) (int, error)
+}
+
+// device returns the device of the current segment, bounded by the context
+// of the operation in progress and the timeout of the member. The device is
+// used as is when there is neither a context that can be done nor a timeout.
+func (m *Member) device() io.ReadWriter {
+	if m.opts.timeout <= 0 && (m.ctx == nil || m.ctx.Done() == nil) {
+		return m.rwc
+	}
+
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return &boundedDevice{m, m.rwc, ctx, m.opts.timeout}
+}
+
+// boundedDevice gives up on reads and writes that do not complete before the
+// context is done or the timeout expires, and fails the member such that the
+// device, which is still busy with the abandoned operation, is not used
+// again. The device works on a private copy of the buffer, which is left to
+// the device when the operation is abandoned and returned to the pool
+// otherwise.
+type boundedDevice struct {
+	m       *Member
+	rwc     io.ReadWriteCloser
+	ctx     context.Context
+	timeout time.Duration
+}
+
+func (d *boundedDevice) Read(p []byte) (int, error) {
+	buf := getBuffer(len(p))
+
+	n, ok, err := d.do(func() (int, error) {
+		if r, ok := d.rwc.(contextReader); ok {
+			return r.ReadContext(d.ctx, *buf)
+		}
+
+		return d.rwc.Read(*buf)
+	})
+
+	if !ok {
+		return 0, err
+	}
+
+	copy(p, (*buf)[:n])
+	putBuffer(buf)
+
+	return n, err
+}
+
+func (d *boundedDevice) Write(p []byte) (int, error) {
+	buf := getBuffer(len(p))
+	copy(*buf, p)
+
+	n, ok, err := d.do(func() (int, error) {
+		if w, ok := d.rwc.(contextWriter); ok {
+			return w.WriteContext(d.ctx, *buf)
+		}
+
+		return d.rwc.Write(*buf)
+	})
+
+	if ok {
+		putBuffer(buf)
+	}
+
+	return n, err
+}
+
+// do runs op and waits for it to complete, for the context to be done or for
+// the timeout to expire. ok is false when op was abandoned, in which case
+// the member is FAILED.
+func (d *boundedDevice) do(op func() (int, error)) (n int, ok bool, err error) {
+	if err := d.ctx.Err(); err != nil {
+		return 0, true, err
+	}
+
+	var expired <-chan time.Time
+	if d.timeout > 0 {
+		timer := time.NewTimer(d.timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	ch := make(chan rwT, 1)
+
+	go func() {
+		n, err := op()
+		ch <- rwT{n: n, err: err}
+	}()
+
+	select {
+	case rc := <-ch:
+		return rc.n, true, rc.err
+	case <-expired:
+		err = ErrTimeout
+	case <-d.ctx.Done():
+		err = d.ctx.Err()
+	}
+
+	// an operation that completed just in time is not abandoned
+	select {
+	case rc := <-ch:
+		return rc.n, true, rc.err
+	default:
+	}
+
+	d.m.fail(err)
+
+	return 0, false, err
+}
+
+// withContext runs fn with ctx bounding the I/O it issues to members. Members
+// with I/O still in progress when ctx is done are considered hung and FAILED.
+// Striping behaviors pass their striper such that no more stripes are started
+// once ctx is done. The writes queued and the reads issued ahead are waited
+// for, such that none runs under another context than the one it was issued
+// with.
+func withContext(ctx context.Context, members []*Member, buf *striper, pipe *pipeline, ahead *readahead, fn func() (int, error)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if err := pipe.flush(); err != nil {
+		return 0, err
+	}
+
+	ahead.settle()
+
+	for _, m := range members {
+		m.ctx = ctx
+	}
+
+	if buf != nil {
+		buf.ctx = ctx
+	}
+
+	n, err := fn()
+
+	if perr := pipe.flush(); err == nil && perr != nil {
+		n, err = 0, perr
+	}
+
+	ahead.settle()
+
+	for _, m := range members {
+		m.ctx = nil
+	}
+
+	if buf != nil {
+		buf.ctx = nil
+	}
+
+	return n, err
+}
+
+// ReadContext is like Read, but gives up on members that do not complete
+// their part before ctx is done. A stripe has no redundancy, so the read
+// fails with them.
+func (s *Stripe) ReadContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, s.ios, s.buf, s.pipe, s.ahead, func() (int, error) { return s.Read(p) })
+}
+
+// WriteContext is like Write, but gives up on members that do not complete
+// their part before ctx is done. A stripe has no redundancy, so the write
+// fails with them.
+func (s *Stripe) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, s.ios, s.buf, s.pipe, s.ahead, func() (int, error) { return s.Write(p) })
+}
+
+// ReadContext is like Read, but gives up on members that do not complete
+// their part before ctx is done and reads from the remaining copies.
+func (m *Mirror) ReadContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, m.ios, nil, m.pipe, m.ahead, func() (int, error) { return m.Read(p) })
+}
+
+// WriteContext is like Write, but gives up on members that do not complete
+// their part before ctx is done and writes the remaining copies.
+func (m *Mirror) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, m.ios, nil, m.pipe, m.ahead, func() (int, error) { return m.Write(p) })
+}
+
+// ReadContext is like Read, but fails if the current member does not complete
+// the read before ctx is done.
+func (c *Concat) ReadContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, c.ios, nil, nil, c.ahead, func() (int, error) { return c.Read(p) })
+}
+
+// WriteContext is like Write, but fails if the current member does not
+// complete the write before ctx is done.
+func (c *Concat) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, c.ios, nil, nil, c.ahead, func() (int, error) { return c.Write(p) })
+}
+
+// ReadContext is like Read, but gives up on members that do not complete
+// their part before ctx is done. The chunk of a single such member is
+// reconstructed from the others.
+func (dp *DedicatedParity) ReadContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, dp.Members(), dp.buf, dp.pipe, dp.ahead, func() (int, error) { return dp.Read(p) })
+}
+
+// WriteContext is like Write, but gives up on members that do not complete
+// their part before ctx is done. The array survives losing one of them.
+func (dp *DedicatedParity) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, dp.Members(), dp.buf, dp.pipe, dp.ahead, func() (int, error) { return dp.Write(p) })
+}
+
+// ReadContext is like Read, but gives up on members that do not complete
+// their part before ctx is done. The chunk of a single such member is
+// reconstructed from the others.
+func (dp *DistributedParity) ReadContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, dp.ios, dp.buf, dp.pipe, dp.ahead, func() (int, error) { return dp.Read(p) })
+}
+
+// WriteContext is like Write, but gives up on members that do not complete
+// their part before ctx is done. The array survives losing one of them.
+func (dp *DistributedParity) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, dp.ios, dp.buf, dp.pipe, dp.ahead, func() (int, error) { return dp.Write(p) })
+}
+
+// ReadContext is like Read, but gives up on members that do not complete
+// their part before ctx is done. The chunks of up to two such members are
+// reconstructed from the others.
+func (dp *DualParity) ReadContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, dp.ios, dp.buf, dp.pipe, dp.ahead, func() (int, error) { return dp.Read(p) })
+}
+
+// WriteContext is like Write, but gives up on members that do not complete
+// their part before ctx is done. The array survives losing two of them.
+func (dp *DualParity) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, dp.ios, dp.buf, dp.pipe, dp.ahead, func() (int, error) { return dp.Write(p) })
+}
+
+// ReadContext is like Read, but gives up on members that do not complete
+// their part before ctx is done. The chunks of as many such members as there
+// are coding members are reconstructed from the others.
+func (ec *ErasureCoded) ReadContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, ec.ios, ec.buf, ec.pipe, ec.ahead, func() (int, error) { return ec.Read(p) })
+}
+
+// WriteContext is like Write, but gives up on members that do not complete
+// their part before ctx is done. The array survives losing as many of them
+// as there are coding members.
+func (ec *ErasureCoded) WriteContext(ctx context.Context, p []byte) (int, error) {
+	return withContext(ctx, ec.ios, ec.buf, ec.pipe, ec.ahead, func() (int, error) { return ec.Write(p) })
+}
@@ -0,0 +1,249 @@
+package streammux_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+// hungDevice stops completing reads and writes once it hangs. They return
+// when the device is released, without touching the underlying device.
+type hungDevice struct {
+	*testutil.BlockDevice
+
+	hang    bool
+	release chan struct{}
+}
+
+func newHungDevice(size int) *hungDevice {
+	return &hungDevice{
+		BlockDevice: testutil.NewBlockDevice(size),
+		release:     make(chan struct{}),
+	}
+}
+
+func (d *hungDevice) Read(p []byte) (int, error) {
+	if d.hang {
+		<-d.release
+		return 0, syscall.EIO
+	}
+
+	return d.BlockDevice.Read(p)
+}
+
+func (d *hungDevice) Write(p []byte) (int, error) {
+	if d.hang {
+		<-d.release
+		return 0, syscall.EIO
+	}
+
+	return d.BlockDevice.Write(p)
+}
+
+func TestMirrorTimeout(t *testing.T) {
+	hung := newHungDevice(1 << 20)
+	defer close(hung.release)
+
+	bus := streammux.NewEventBus()
+
+	sub := bus.Subscribe(16)
+	defer sub.Close()
+
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{testutil.NewBlockDevice(1 << 20), hung},
+		streammux.WithTimeout(50*time.Millisecond),
+		streammux.WithEvents(bus),
+	)
+
+	data := make([]byte, 1<<14)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+
+	if _, err := m.Write(data[:4096]); err != nil {
+		t.Fatal(err)
+	}
+
+	hung.hang = true
+
+	start := time.Now()
+
+	// the timeout is reported along with the data written
+	failures := expectFailures(t, 1)
+
+	for p := data[4096:]; len(p) > 0; p = p[4096:] {
+		n, err := m.Write(p[:4096])
+		failures.check(n, 4096, err)
+	}
+
+	failures.done()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the hung member to be given up on, took %v", elapsed)
+	}
+
+	if m.Health() != streammux.DEGRADED || m.Members()[1].State() != streammux.FAILED {
+		t.Fatal("expected the hung member to fail and the mirror to be DEGRADED")
+	}
+
+	ev := nextEvent(t, sub)
+	expectEvent(t, ev, streammux.MemberFailed, m, 1)
+
+	if !errors.Is(ev.Err, streammux.ErrTimeout) || !errors.Is(ev.Err, context.DeadlineExceeded) {
+		t.Fatalf("expected the member to time out, got %v", ev.Err)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+	defer m.Close()
+
+	got, err := io.ReadAll(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read differs from the data written")
+	}
+}
+
+func TestDedicatedParityReadContext(t *testing.T) {
+	hung := newHungDevice(1 << 20)
+	defer close(hung.release)
+
+	dp := streammux.NewDedicatedParity(testutil.NewBlockDevice(1<<20), []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		hung,
+		testutil.NewBlockDevice(1 << 20),
+	}, streammux.WithStripeUnit(512))
+
+	data := make([]byte, 1<<14)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	writeArray(t, dp, data)
+
+	dp.Open()
+	defer dp.Close()
+
+	// the member hangs once the superblocks have been read by Open
+	hung.hang = true
+
+	got := make([]byte, 0, len(data))
+	p := make([]byte, 4096)
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		n, err := dp.ReadContext(ctx, p)
+		cancel()
+
+		got = append(got, p[:n]...)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data reconstructed without the hung member differs from the data written")
+	}
+
+	if dp.Health() != streammux.DEGRADED {
+		t.Fatal("expected the array to be DEGRADED without the hung member")
+	}
+
+	// an operation is not started once its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := dp.ReadContext(ctx, p); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the read to be cancelled, got %v", err)
+	}
+}
+
+// holdingDevice keeps the buffers of the writes it hangs on, and a copy of
+// their contents when the write was issued.
+type holdingDevice struct {
+	*hungDevice
+
+	sync.Mutex
+	held, want [][]byte
+}
+
+func (d *holdingDevice) Write(p []byte) (int, error) {
+	if d.hang {
+		d.Lock()
+		d.held = append(d.held, p)
+		d.want = append(d.want, append([]byte(nil), p...))
+		d.Unlock()
+	}
+
+	return d.hungDevice.Write(p)
+}
+
+func TestMirrorTimeoutAbandonedWrite(t *testing.T) {
+	hung := &holdingDevice{hungDevice: newHungDevice(1 << 20)}
+
+	spares := streammux.NewSparePool([]io.ReadWriteCloser{testutil.NewBlockDevice(1 << 20)})
+	defer spares.Shutdown()
+
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{testutil.NewBlockDevice(1 << 20), hung},
+		streammux.WithTimeout(50*time.Millisecond),
+		streammux.WithSparePool(spares),
+	)
+
+	data := make([]byte, 1<<15)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+	defer m.Close()
+
+	hung.hang = true
+
+	// the hung member is failed rather than moved on to a spare, as its
+	// device is still busy with the abandoned write
+	for p := data; len(p) > 0; p = p[4096:] {
+		if _, err := m.Write(p[:4096]); errors.Is(err, streammux.ErrArrayFailed) {
+			t.Fatal(err)
+		}
+	}
+
+	if m.Members()[1].State() != streammux.FAILED || spares.Available() != 1 {
+		t.Fatal("expected the hung member to fail without taking a spare")
+	}
+
+	// the buffer held by the abandoned write is not handed out again
+	hung.Lock()
+	defer hung.Unlock()
+
+	if len(hung.held) != 1 {
+		t.Fatalf("expected a single abandoned write, got %d", len(hung.held))
+	}
+
+	if !bytes.Equal(hung.held[0], hung.want[0]) {
+		t.Fatal("the buffer of the abandoned write was reused")
+	}
+
+	close(hung.release)
+}
@@ -0,0 +1,269 @@
+package streammux
+
+import (
+	"io"
+)
+
+// copyBufferSize is the minimum size of the buffers used by ReadFrom and
+// WriteTo. Striping behaviors round it up to a multiple of the stripe size,
+// such that every buffer is written as full stripes.
+const copyBufferSize = 1 << 20
+
+// copyBuffers is the number of buffers cycled through by ReadFrom and
+// WriteTo, such that one is read while the other is written.
+const copyBuffers = 2
+
+// copySize returns the size of the copy buffers for stripes of size bytes.
+func copySize(stripe int) int {
+	if stripe <= 0 {
+		return copyBufferSize
+	}
+
+	return (copyBufferSize + stripe - 1) / stripe * stripe
+}
+
+// readFrom reads r until EOF into buffers of size bytes and writes each with
+// write. The next buffer is read from r while the previous one is written.
+// Only the last buffer may be short. readFrom does not return before it is
+// done reading from r.
+//
+// Member failures of buffers that were written regardless do not stop the
+// copy. They are returned together once r is exhausted, such that a degraded
+// array takes the whole stream.
+func readFrom(r io.Reader, size int, write func(p []byte) (int, error)) (n int64, err error) {
+	full := make(chan []byte, copyBuffers)
+	free := make(chan []byte, copyBuffers)
+
+	for i := 0; i < copyBuffers; i++ {
+		free <- make([]byte, size)
+	}
+
+	// closed when a write fails such that no more is read from r
+	stop := make(chan struct{})
+
+	// the error of the last read from r, set before full is closed
+	var rerr error
+
+	go func() {
+		defer close(full)
+
+		for {
+			var p []byte
+
+			select {
+			case p = <-free:
+			case <-stop:
+				return
+			}
+
+			k, err := io.ReadFull(r, p[:cap(p)])
+			if k > 0 {
+				select {
+				case full <- p[:k]:
+				case <-stop:
+					return
+				}
+			}
+
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+
+			if err != nil {
+				rerr = err
+				return
+			}
+		}
+	}()
+
+	var failures MemberErrors
+
+	for p := range full {
+		k, werr := write(p)
+		n += int64(k)
+
+		if werr != nil && k == len(p) && isMemberError(werr) {
+			failures.add(werr)
+			werr = nil
+		}
+
+		if werr != nil {
+			err = werr
+			close(stop)
+
+			// wait for the read in progress, if any
+			for range full {
+			}
+
+			return n, err
+		}
+
+		free <- p
+	}
+
+	if rerr != nil {
+		return n, rerr
+	}
+
+	return n, failures.survived()
+}
+
+// writeTo reads with read into buffers of size bytes until the end of the
+// stream and writes the data to w. The next buffer is read while the previous
+// one is written to w.
+func writeTo(w io.Writer, size int, read func(p []byte) (int, error)) (n int64, err error) {
+	full := make(chan []byte, copyBuffers)
+	free := make(chan []byte, copyBuffers)
+
+	for i := 0; i < copyBuffers; i++ {
+		free <- make([]byte, size)
+	}
+
+	// closed once a write to w fails and the error is set
+	failed := make(chan struct{})
+	done := make(chan struct{})
+
+	var werr error
+
+	go func() {
+		defer close(done)
+
+		for p := range full {
+			if werr == nil {
+				k, err := w.Write(p)
+				n += int64(k)
+
+				if err == nil && k < len(p) {
+					err = io.ErrShortWrite
+				}
+
+				if err != nil {
+					werr = err
+					close(failed)
+				}
+			}
+
+			free <- p
+		}
+	}()
+
+	for {
+		var p []byte
+
+		select {
+		case p = <-free:
+		case <-failed:
+		}
+
+		if p == nil {
+			break
+		}
+
+		k, rerr := read(p[:cap(p)])
+		if k > 0 {
+			full <- p[:k]
+		} else {
+			free <- p
+		}
+
+		if rerr == io.EOF {
+			break
+		}
+
+		if rerr != nil {
+			err = rerr
+			break
+		}
+	}
+
+	close(full)
+	<-done
+
+	if werr != nil {
+		err = werr
+	}
+
+	return n, err
+}
+
+// ReadFrom implements io.ReaderFrom. It writes r to the stripe in buffers of
+// whole stripes, reading the next buffer while the previous one is written.
+func (s *Stripe) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(r, copySize(s.buf.size()), s.Write)
+}
+
+// WriteTo implements io.WriterTo. It reads the stripe into w in buffers of
+// whole stripes.
+func (s *Stripe) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, copySize(s.buf.size()), s.Read)
+}
+
+// ReadFrom implements io.ReaderFrom. It writes r to the mirror, reading the
+// next buffer while the previous one is written.
+func (m *Mirror) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(r, copyBufferSize, m.Write)
+}
+
+// WriteTo implements io.WriterTo.
+func (m *Mirror) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, copyBufferSize, m.Read)
+}
+
+// ReadFrom implements io.ReaderFrom. It writes r to the members in turn,
+// reading the next buffer while the previous one is written.
+func (c *Concat) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(r, copyBufferSize, c.Write)
+}
+
+// WriteTo implements io.WriterTo.
+func (c *Concat) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, copyBufferSize, c.Read)
+}
+
+// ReadFrom implements io.ReaderFrom. It writes r to the array in buffers of
+// whole stripes, reading the next buffer while the previous one is written.
+func (dp *DedicatedParity) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(r, copySize(dp.buf.size()), dp.Write)
+}
+
+// WriteTo implements io.WriterTo. It reads the array into w in buffers of
+// whole stripes.
+func (dp *DedicatedParity) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, copySize(dp.buf.size()), dp.Read)
+}
+
+// ReadFrom implements io.ReaderFrom. It writes r to the array in buffers of
+// whole stripes, reading the next buffer while the previous one is written.
+func (dp *DistributedParity) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(r, copySize(dp.buf.size()), dp.Write)
+}
+
+// WriteTo implements io.WriterTo. It reads the array into w in buffers of
+// whole stripes.
+func (dp *DistributedParity) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, copySize(dp.buf.size()), dp.Read)
+}
+
+// ReadFrom implements io.ReaderFrom. It writes r to the array in buffers of
+// whole stripes, reading the next buffer while the previous one is written.
+func (dp *DualParity) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(r, copySize(dp.buf.size()), dp.Write)
+}
+
+// WriteTo implements io.WriterTo. It reads the array into w in buffers of
+// whole stripes.
+func (dp *DualParity) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, copySize(dp.buf.size()), dp.Read)
+}
+
+// ReadFrom implements io.ReaderFrom. It writes r to the array in buffers of
+// whole stripes, reading the next buffer while the previous one is written.
+func (ec *ErasureCoded) ReadFrom(r io.Reader) (int64, error) {
+	return readFrom(r, copySize(ec.buf.size()), ec.Write)
+}
+
+// WriteTo implements io.WriterTo. It reads the array into w in buffers of
+// whole stripes.
+func (ec *ErasureCoded) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, copySize(ec.buf.size()), ec.Read)
+}
@@ -0,0 +1,168 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+// chunkReader returns at most size bytes per read, like a pipe or a socket.
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > r.size {
+		p = p[:r.size]
+	}
+
+	return r.r.Read(p)
+}
+
+// failingWriter fails every write after the first n bytes.
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		k := w.n
+		w.n = 0
+		return k, syscall.EIO
+	}
+
+	w.n -= len(p)
+
+	return len(p), nil
+}
+
+func TestCopy(t *testing.T) {
+	arrays := map[string]func([]io.ReadWriteCloser) streammux.Array{
+		"Mirror":          newBenchMirror,
+		"Stripe":          newBenchStripe,
+		"DedicatedParity": newBenchDedicatedParity,
+	}
+
+	data := make([]byte, 3<<20+1234)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, newArray := range arrays {
+		t.Run(name, func(t *testing.T) {
+			a := newArray(blockDevices(4))
+
+			a.Open()
+
+			// io.Copy hands the source to ReadFrom
+			n, err := io.Copy(a, &chunkReader{bytes.NewReader(data), 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != int64(len(data)) {
+				t.Fatalf("expected %d bytes to be copied in, got %d", len(data), n)
+			}
+
+			if err := a.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			a.Open()
+
+			var buf bytes.Buffer
+
+			// and the destination to WriteTo
+			if n, err = io.Copy(&buf, a); err != nil {
+				t.Fatal(err)
+			}
+
+			if n != int64(len(data)) || !bytes.Equal(data, buf.Bytes()) {
+				t.Fatal("data copied out differs from the data copied in")
+			}
+
+			a.Close()
+
+			// a failing destination stops the copy
+			a.Open()
+			defer a.Close()
+
+			n, err = a.(io.WriterTo).WriteTo(&failingWriter{n: 5000})
+			if !errors.Is(err, syscall.EIO) || n != 5000 {
+				t.Fatalf("expected the write error after 5000 bytes, got %d bytes and %v", n, err)
+			}
+		})
+	}
+}
+
+func TestReadFromMemberError(t *testing.T) {
+	s := streammux.NewStripe([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 22),
+		testutil.NewFaultyDevice(1<<22, 3),
+	}, streammux.WithStripeUnit(512))
+
+	s.Open()
+	defer s.Close()
+
+	_, err := s.ReadFrom(bytes.NewReader(make([]byte, 1<<22)))
+
+	var merr *streammux.MemberError
+	if !errors.As(err, &merr) || merr.Member != 1 {
+		t.Fatalf("expected a write error on member 1, got %v", err)
+	}
+}
+
+func TestReadFromDegraded(t *testing.T) {
+	dp := streammux.NewDistributedParity([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 22),
+		testutil.NewBlockDevice(1 << 22),
+		testutil.NewFaultyDevice(1<<22, 10),
+		testutil.NewBlockDevice(1 << 22),
+	}, streammux.WithStripeUnit(4096))
+
+	data := make([]byte, 8<<20)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+
+	// the source is not an io.WriterTo, such that io.Copy hands it to
+	// ReadFrom, which copies past the failure of the member
+	n, err := io.Copy(dp, io.LimitReader(bytes.NewReader(data), int64(len(data))))
+	if n != int64(len(data)) {
+		t.Fatalf("expected %d bytes to be copied in, got %d: %v", len(data), n, err)
+	}
+
+	var merr *streammux.MemberError
+	if !errors.As(err, &merr) || merr.Member != 2 {
+		t.Fatalf("expected a write error on member 2, got %v", err)
+	}
+
+	if dp.Health() != streammux.DEGRADED {
+		t.Fatal("expected the array to be DEGRADED")
+	}
+
+	if err := dp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	defer dp.Close()
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, dp); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Fatal("data copied out differs from the data copied in")
+	}
+}
@@ -1,9 +1,12 @@
 package streammux
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
 	"sync"
-	"syscall"
+	"time"
 )
 
 // DedicatedParity is a redundancy behavior with a stripe and a parity device similar to RAID-4.
@@ -12,137 +15,305 @@ type DedicatedParity struct {
 
 	stripe []*Member
 	parity *Member
+	buf    *striper
 
-	state    State
-	replaced chan int
+	// the array identity shared by the members
+	array *array
+
+	// the reads and writes in flight on the members
+	ahead *readahead
+	pipe  *pipeline
+
+	// lists reused from stripe to stripe holding the parts of the stripe, the
+	// chunks read from the members and the chunks a missing chunk is
+	// reconstructed from
+	chunks    StripeBufferList
+	read      StripeBufferList
+	survivors StripeBufferList
+
+	state health
+	opts  memberOptions
+
+	// rebuilds of replaced members waiting for Sync and the rebuild running,
+	// if any
+	replaced chan *RebuildJob
+	job      *RebuildJob
+
+	// whether the rebuild journal has been checked for an interrupted rebuild
+	resumed bool
+
+	// the member a read failed on in this session, -1 if none. Reads issued
+	// ahead to it before it failed are reconstructed.
+	lost int
 }
 
+// NewDedicatedParity returns a DedicatedParity of the stripe members and the
+// parity member. Media holding an array of the same geometry is adopted when
+// opened and blank media is formatted.
 func NewDedicatedParity(parity io.ReadWriteCloser, stripe []io.ReadWriteCloser, opts ...MemberOption) *DedicatedParity {
 	dp := &DedicatedParity{
 		stripe:   make([]*Member, len(stripe)),
 		parity:   NewMember(parity, opts...),
-		replaced: make(chan int),
+		replaced: make(chan *RebuildJob),
+		lost:     -1,
+
+		chunks:    make(StripeBufferList, len(stripe)),
+		read:      make(StripeBufferList, len(stripe)+1),
+		survivors: make(StripeBufferList, len(stripe)),
 	}
 
 	for i, rwc := range stripe {
 		dp.stripe[i] = NewMember(rwc, opts...)
 	}
 
+	o := newMemberOptions(opts)
+	dp.opts = o
+	dp.ahead = newReadahead(o.readAhead, len(stripe)+1, dp.issueRead, dp.completeRead)
+	dp.buf = newStriper(o.stripeUnit, len(stripe), dp.ahead.read, dp.writeStripe)
+
+	dp.array = attach(dp, KindDedicatedParity, len(stripe), o.stripeUnit, append(dp.stripe, dp.parity))
+	dp.pipe = newPipeline(o.queueDepth, len(stripe)+1, dp.completeWrite)
+
+	go dp.Sync()
+
 	return dp
 }
 
 func (dp *DedicatedParity) Health() State {
-	return dp.state
+	return dp.state.get()
+}
+
+// Members returns the members of the behavior.
+func (dp *DedicatedParity) Members() []*Member {
+	return append(dp.stripe, dp.parity)
+}
+
+// member returns the member at idx, where the parity member follows the last
+// stripe member.
+func (dp *DedicatedParity) member(idx int) *Member {
+	if idx == len(dp.stripe) {
+		return dp.parity
+	}
+
+	return dp.stripe[idx]
+}
+
+// degraded reports whether any of the members are DEGRADED.
+func (dp *DedicatedParity) degraded() bool {
+	return anyDegraded(dp.stripe) || dp.parity.State() == DEGRADED
 }
 
 func (dp *DedicatedParity) Open() State {
 	dp.Lock()
 
+	// foreground I/O takes priority over a running rebuild
+	if dp.job != nil {
+		dp.job.suspend()
+	}
+
+	if !dp.resumed {
+		dp.resumed = true
+		dp.resume()
+	}
+
+	// reset state
+	dp.state.set(OK)
+	dp.lost = -1
+	dp.buf.reset()
+
 	var failed bool
 
 	for _, rwc := range append(dp.stripe, dp.parity) {
-		state := rwc.Open()
+		// members awaiting or undergoing a rebuild are left to Sync
+		state := FAILED
+		if !rebuilding(rwc) {
+			state = rwc.Open()
+		}
+
 		switch state {
 		case FAILED:
 			if failed {
-				dp.state = FAILED
+				dp.state.set(FAILED)
 			} else {
-				dp.state = DEGRADED
+				dp.state.set(DEGRADED)
 			}
+
+			failed = true
 		case DEGRADED:
-			if dp.state == FAILED {
+			if dp.state.get() == FAILED {
 				break
 			}
 
-			dp.state = DEGRADED
+			dp.state.set(DEGRADED)
 		}
 	}
 
-	return dp.state
+	dp.array.observe()
+
+	return dp.state.get()
 }
 
 func (dp *DedicatedParity) Close() (err error) {
 	defer dp.Unlock()
 
-	for _, closer := range dp.stripe {
+	flushErr := dp.buf.flush()
+
+	// wait for the queued writes, including the final stripe
+	if perr := dp.pipe.flush(); flushErr == nil {
+		flushErr = perr
+	}
+
+	dp.ahead.reset()
+
+	for _, closer := range append(dp.stripe, dp.parity) {
+		if rebuilding(closer) {
+			continue
+		}
+
 		err = closer.Close()
 	}
 
-	err = dp.parity.Close()
+	if flushErr != nil {
+		err = flushErr
+	}
 
 	return
 }
 
 func (dp *DedicatedParity) Read(p []byte) (n int, err error) {
-	// THIS IS PRETTY HAIRY STUFF
+	defer dp.array.observe()
+
+	return dp.buf.read(p)
+}
+
+func (dp *DedicatedParity) Write(p []byte) (n int, err error) {
+	defer dp.array.observe()
+
+	return dp.buf.write(p)
+}
 
+// issueRead submits the reads of a single stripe into r.p. The member that
+// is not read, if any, is recorded in r.idx.
+func (dp *DedicatedParity) issueRead(r *inflight) error {
 	// bail out if we're already marked as FAILED
-	if dp.state == FAILED {
-		return 0, syscall.EIO
+	if dp.state.get() == FAILED {
+		return ErrArrayFailed
 	}
 
-	// create a channel for I/O requests
-	ch := make(chan rwT)
+	if len(r.p)%len(dp.stripe) != 0 {
+		return errStripeWidth
+	}
 
-	// an esoteric counter (to get a nice range loop later)
-	var active []struct{}
+	// the list is cleared on return such that p is not held on to
+	stripe := dp.chunks
+	defer stripe.reset()
 
-	stripe := split(p, len(dp.stripe))
-	reconstructIdx := -1
+	stripe.split(r.p)
+
+	// the parity chunk is only needed for reconstruction and is read into a
+	// buffer from the pool
+	parity := getBuffer(len(stripe[0]))
+	r.hold(parity)
 
 	// loop over all members (stripe members and the parity member)
-	for i, reader := range append(dp.stripe, dp.parity) {
+	for i := 0; i <= len(dp.stripe); i++ {
+		reader := dp.member(i)
+
 		// check if the member is failed and record the index
-		if reader.State() != OK {
-			reconstructIdx = i
+		if !reader.usable() {
+			r.idx = i
 
 			// don't issue a read request to this member if not OK
 			continue
 		}
 
-		// issue the read request in a seperate process
-		go reader.read(i, make([]byte, len(p)/len(dp.stripe)), ch)
+		// stripe members read directly into their part of p
+		buf := *parity
+		if i < len(dp.stripe) {
+			buf = stripe[i]
+		}
 
-		// record that we issues a request and must get an answer
-		active = append(active, struct{}{})
+		// queue the read request on the worker of the member
+		r.fetch(reader, i, buf)
 	}
 
-	tmp := make(StripeBufferList, len(dp.stripe)+1)
+	return nil
+}
 
-	for range active {
-		rc := <-ch
+// completeRead collects the results of a read issued by issueRead and
+// reconstructs the chunk of the member that was not read or failed.
+func (dp *DedicatedParity) completeRead(r *inflight) (n int, err error) {
+	// THIS IS PRETTY HAIRY STUFF
 
-		n += rc.n
-		err = rc.err
+	// the lists are cleared on return such that p is not held on to
+	stripe, tmp := dp.chunks, dp.read
+	defer stripe.reset()
+	defer tmp.reset()
+
+	stripe.split(r.p)
+
+	reconstructIdx := r.idx
+
+	for i := 0; i < r.active; i++ {
+		rc := <-r.results
+
+		// a corrupt chunk is reconstructed like a missing one, but the member
+		// remains usable
+		if errors.Is(rc.err, ErrChecksum) {
+			if reconstructIdx != -1 {
+				dp.state.set(FAILED)
+			} else {
+				reconstructIdx = rc.idx
+			}
+
+			continue
+		}
 
-		if err != nil && err != io.EOF {
-			if dp.state == DEGRADED {
+		if rc.err != nil && rc.err != io.EOF {
+			// the read was issued ahead before an earlier stripe failed
+			// the member
+			if rc.idx == dp.lost && reconstructIdx == -1 {
+				reconstructIdx = rc.idx
+				continue
+			}
+
+			if dp.state.get() == DEGRADED || reconstructIdx != -1 {
 				// if already DEGRADED mark us as FAILED
-				dp.state = FAILED
+				dp.state.set(FAILED)
 			} else {
 				// if not, just mark us DEGRADED and record the index to reconstruct
-				dp.state = DEGRADED
+				dp.state.set(DEGRADED)
 				reconstructIdx = rc.idx
 
 				// mark the correct stripe member or the parity member
-				if rc.idx == len(dp.stripe) {
-					dp.parity.SetState(FAILED)
-				} else {
-					dp.stripe[rc.idx].SetState(FAILED)
-				}
+				dp.member(rc.idx).SetState(FAILED)
+				dp.lost = rc.idx
 			}
 
 			continue
 		}
 
+		// failures are handled above, only EOF is passed on
+		err = rc.err
+
+		// only count the bytes that end up in p. The bytes of a failed chunk
+		// are counted once it has been reconstructed.
+		if rc.idx != len(dp.stripe) {
+			n += rc.n
+		}
+
 		// save for reconstruction
 		tmp[rc.idx] = rc.p[:rc.n]
 	}
 
-	// perform XOR only if one of the stripe members is FAILED
-	if dp.state == DEGRADED && reconstructIdx != len(dp.stripe) {
+	if dp.state.get() == FAILED {
+		return 0, ErrArrayFailed
+	}
 
-		tmp2 := make(StripeBufferList, len(dp.stripe))
+	// perform XOR only if one of the stripe chunks is missing
+	if reconstructIdx != -1 && reconstructIdx != len(dp.stripe) {
+		tmp2 := dp.survivors
+		defer tmp2.reset()
 
 		var j int
 		for i, buf := range tmp {
@@ -151,72 +322,79 @@ func (dp *DedicatedParity) Read(p []byte) (n int, err error) {
 			}
 
 			tmp2[j] = buf
-			copy(stripe[j], buf)
 			j++
 		}
 
-		copy(stripe[reconstructIdx], tmp2.XOR())
+		dst := stripe[reconstructIdx][:len(tmp2[0])]
+		tmp2.XORInto(dst)
+
+		n += len(dst)
 
 		return
 	}
 
-	// if all is good, just copy the buffers into the stripe
-	for i, buf := range tmp[:len(tmp)-1] {
-		copy(stripe[i], buf)
+	if dp.state.get() == OK && dp.degraded() {
+		dp.state.set(DEGRADED)
 	}
 
 	return
 }
 
-func (dp *DedicatedParity) Write(p []byte) (n int, err error) {
-	if dp.state == FAILED {
-		return 0, syscall.EIO
+// writeStripe writes p as a single stripe and its parity.
+func (dp *DedicatedParity) writeStripe(p []byte) (n int, err error) {
+	if dp.state.get() == FAILED {
+		return 0, ErrArrayFailed
 	}
 
-	ch := make(chan rwT)
-
-	var active []struct{}
-
-	stripe := split(p, len(dp.stripe))
+	if len(p)%len(dp.stripe) != 0 {
+		return 0, errStripeWidth
+	}
 
-	if dp.parity.State() == OK {
-		go func() {
-			r := stripe.XOR()
+	w, p := dp.pipe.begin(p)
 
-			dp.parity.write(len(dp.stripe), r, ch)
-		}()
+	stripe := dp.chunks
+	defer stripe.reset()
 
-		active = append(active, struct{}{})
-	}
+	stripe.split(p)
 
 	for i, writer := range dp.stripe {
-		if writer.State() != OK {
+		if !writer.usable() {
 			continue
 		}
 
-		go writer.write(i, stripe[i], ch)
+		w.submit(writer, i, stripe[i])
+	}
+
+	// the parity is computed while the stripe members are written
+	if dp.parity.usable() {
+		parity := getBuffer(len(stripe[0]))
+		w.hold(parity)
 
-		active = append(active, struct{}{})
+		stripe.XORInto(*parity)
+
+		w.submit(dp.parity, len(dp.stripe), *parity)
 	}
 
+	return dp.pipe.end(w)
+}
+
+// completeWrite collects the results of a write issued by writeStripe.
+func (dp *DedicatedParity) completeWrite(w *inflight) (n int, err error) {
 	var writeSucceeded bool
 	var numGoodWrites int
-	for range active {
-		rc := <-ch
+	var failures MemberErrors
+
+	for i := 0; i < w.active; i++ {
+		rc := <-w.results
 
 		n += rc.n
 
 		if rc.err != nil && rc.err != io.EOF {
-			if dp.state == DEGRADED {
-				dp.state = FAILED
-			} else {
-				dp.state = DEGRADED
-				if rc.idx == len(dp.stripe) {
-					dp.parity.SetState(FAILED)
-				} else {
-					dp.stripe[rc.idx].SetState(FAILED)
-				}
-			}
+			failures.add(rc.err)
+
+			// writes queued to a member that has failed since fail with
+			// it, which does not count as another failure
+			dp.fail(rc.idx)
 
 			n -= rc.n
 
@@ -238,9 +416,428 @@ func (dp *DedicatedParity) Write(p []byte) (n int, err error) {
 		}
 	}
 
-	if dp.state == OK {
-		n -= len(p) / len(dp.stripe)
+	// don't count the parity chunk if every member was written
+	if numGoodWrites == len(dp.stripe)+1 {
+		n -= w.size / len(dp.stripe)
+	}
+
+	if dp.state.get() == OK && dp.degraded() {
+		dp.state.set(DEGRADED)
+	}
+
+	if writeSucceeded && err == nil {
+		return n, failures.survived()
+	}
+
+	return n, failures.join(err)
+}
+
+// Replace replaces the member at idx with rwc and signals the Sync function
+// to begin rebuilding it. The stripe members are numbered from zero and the
+// parity member follows the last stripe member.
+func (dp *DedicatedParity) Replace(idx int, rwc io.ReadWriteCloser) {
+	dp.ReplaceContext(context.Background(), idx, rwc)
+}
+
+// ReplaceContext replaces the member at idx with rwc and returns the job
+// rebuilding it. The rebuild runs in the background and is cancelled with
+// ctx.
+//
+// The array remains usable while the rebuild runs. Opening the array
+// suspends the rebuild, which continues once the array is closed again. It
+// starts over if a new stream was written in the meantime.
+func (dp *DedicatedParity) ReplaceContext(ctx context.Context, idx int, rwc io.ReadWriteCloser) *RebuildJob {
+	dp.Lock()
+
+	old := dp.member(idx)
+
+	// the new member takes the place of the old one in the array
+	member := NewMember(rwc)
+	member.opts = old.opts
+	member.array, member.index = old.array, idx
+
+	// a running rebuild lets go of the members it holds open
+	if dp.job != nil {
+		dp.job.suspend()
+	}
+
+	if idx == len(dp.stripe) {
+		dp.parity = member
+	} else {
+		dp.stripe[idx] = member
+	}
+
+	// the old member is discarded, so a failure to close it is of no concern
+	old.release()
+
+	dp.Unlock()
+
+	return dp.resync(ctx, idx)
+}
+
+// resync returns the job rebuilding the member at idx onto its own devices,
+// keeping the spare segments it has moved on to.
+func (dp *DedicatedParity) resync(ctx context.Context, idx int) *RebuildJob {
+	dp.Lock()
+	dp.member(idx).SetState(REPLACED)
+	dp.Unlock()
+
+	job := newRebuildJob(ctx, idx, dp.opts)
+
+	dp.replaced <- job
+
+	return job
+}
+
+// Rebuild returns the rebuild that is running or about to run, if any.
+func (dp *DedicatedParity) Rebuild() *RebuildJob {
+	dp.Lock()
+	defer dp.Unlock()
+
+	return dp.job
+}
+
+// resume queues the rebuild recorded in the rebuild journal, if it belongs to
+// the array. The array must be locked.
+func (dp *DedicatedParity) resume() {
+	if job := resumeRebuild(dp.Members(), dp.opts); job != nil {
+		dp.job = job
+		dp.replaced <- job
+	}
+}
+
+// Sync runs the rebuilds of replaced members, one at a time. The contents of
+// a replaced member is the XOR of the chunks on the remaining members,
+// whether it is a stripe member or the parity member.
+func (dp *DedicatedParity) Sync() {
+	for job := range dp.replaced {
+		dp.rebuild(job)
+	}
+}
+
+// fail marks the member at idx as FAILED. The array fails once more than one
+// member is unusable, counting members that are being rebuilt.
+func (dp *DedicatedParity) fail(idx int) {
+	dp.member(idx).SetState(FAILED)
+
+	if dp.numFailed() > 1 {
+		dp.state.set(FAILED)
+	} else {
+		dp.state.set(DEGRADED)
+	}
+}
+
+// numFailed returns the number of members that are not usable.
+func (dp *DedicatedParity) numFailed() (n int) {
+	for _, m := range dp.Members() {
+		if !m.usable() {
+			n++
+		}
 	}
 
 	return
 }
+
+// rebuild writes the XOR of the chunks of the other members to the replaced
+// member one stripe at a time, holding the array lock only while rebuilding
+// a stripe. The chunk buffers are taken from the pool once per rebuild.
+func (dp *DedicatedParity) rebuild(job *RebuildJob) {
+	dp.Lock()
+	dp.job = job
+	dst := dp.member(job.idx)
+	dp.Unlock()
+
+	dst.emit(RebuildStarted, nil)
+
+	unit := dp.buf.unit
+
+	bufs := make([]*[]byte, len(dp.stripe)+1)
+	for i := range bufs {
+		bufs[i] = getBuffer(unit)
+	}
+
+	defer func() {
+		for _, buf := range bufs {
+			putBuffer(buf)
+		}
+	}()
+
+	ch := make(chan rwT)
+
+	for {
+		if err := job.ctx.Err(); err != nil {
+			dp.finish(job, err)
+			return
+		}
+
+		dp.Lock()
+
+		if !job.open {
+			if err := dp.startRebuild(job); err != nil {
+				dp.Unlock()
+				dp.finish(job, err)
+				return
+			}
+		}
+
+		err := dp.rebuildStripe(job, bufs, ch)
+
+		if err == nil && time.Since(job.checkpoint) >= checkpointInterval {
+			job.save()
+		}
+
+		dp.Unlock()
+
+		if err == io.EOF {
+			dp.finish(job, nil)
+			return
+		}
+
+		if err != nil {
+			dp.finish(job, err)
+			return
+		}
+
+		job.throttle()
+	}
+}
+
+// startRebuild opens the other members and the member being rebuilt and
+// positions them at the offset the rebuild continues from. Every other
+// member is needed to reconstruct the replaced one. The array must be
+// locked.
+func (dp *DedicatedParity) startRebuild(job *RebuildJob) error {
+	var srcs []*Member
+
+	for i, member := range dp.Members() {
+		if i == job.idx {
+			continue
+		}
+
+		if !member.usable() {
+			return ErrNoRedundancy
+		}
+
+		srcs = append(srcs, member)
+	}
+
+	job.begin(srcs, dp.member(job.idx), 0)
+
+	return nil
+}
+
+// rebuildStripe reads a stripe from the other members into bufs and writes
+// the XOR of its chunks to the member being rebuilt. It returns io.EOF once
+// the last stripe has been rebuilt. The array must be locked.
+func (dp *DedicatedParity) rebuildStripe(job *RebuildJob, bufs []*[]byte, ch chan rwT) (err error) {
+	unit := dp.buf.unit
+
+	for i, src := range job.srcs {
+		src.submit(OpRead, i, *bufs[i], ch)
+	}
+
+	chunks := dp.survivors
+	defer chunks.reset()
+
+	size := unit
+	var eof bool
+
+	for range job.srcs {
+		rc := <-ch
+
+		if rc.err != nil && rc.err != io.EOF && err == nil {
+			err = rc.err
+		}
+
+		if rc.err == io.EOF {
+			eof = true
+		}
+
+		if rc.n < size {
+			size = rc.n
+		}
+
+		chunks[rc.idx] = rc.p
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		return io.EOF
+	}
+
+	for i := range chunks {
+		chunks[i] = chunks[i][:size]
+	}
+
+	out := (*bufs[len(job.srcs)])[:size]
+	chunks.XORInto(out)
+
+	_, err = job.dst.Write(out)
+
+	job.mu.Lock()
+	job.done = int64(job.dst.pos)
+	job.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// a short stripe ends the stream
+	if eof || size < unit {
+		return io.EOF
+	}
+
+	return nil
+}
+
+// finish ends the rebuild with err.
+func (dp *DedicatedParity) finish(job *RebuildJob, err error) {
+	dp.Lock()
+
+	job.suspend()
+
+	dst := dp.member(job.idx)
+
+	switch {
+	case err == nil:
+		dst.SetState(OK)
+
+		if dp.state.get() == DEGRADED && !dp.degraded() && dp.numFailed() == 0 {
+			dp.state.set(OK)
+		}
+	case dst.State() != FAILED:
+		// the member still awaits a rebuild
+		dst.SetState(REPLACED)
+	}
+
+	job.forget(dst)
+
+	dp.job = nil
+
+	dp.Unlock()
+
+	dp.array.observe()
+	dst.emit(RebuildFinished, err)
+
+	job.mu.Lock()
+	job.err = err
+	job.mu.Unlock()
+
+	close(job.finished)
+}
+
+// Scrub reads every member end to end and verifies that each parity chunk is
+// the XOR of the chunks of its stripe. A chunk that fails checksum
+// verification or ends early is held responsible for the mismatch. Otherwise
+// the parity chunk is, as bad data cannot be told apart from bad parity.
+//
+// If repair is set and every mismatch is attributed to the same member, that
+// member is rebuilt from the others onto its own devices, and Scrub returns
+// once the rebuild has finished.
+func (dp *DedicatedParity) Scrub(ctx context.Context, repair bool) (*ScrubReport, error) {
+	dp.Open()
+	report, err := dp.scrub(ctx)
+	dp.Close()
+
+	if err != nil || !repair {
+		return report, err
+	}
+
+	culprits := report.culprits()
+	if len(culprits) != 1 {
+		return report, nil
+	}
+
+	job := dp.resync(ctx, culprits[0])
+	report.Repaired = culprits
+
+	<-job.Done()
+
+	if err := job.Err(); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// scrub verifies the parity of every stripe. The array must be open.
+func (dp *DedicatedParity) scrub(ctx context.Context) (*ScrubReport, error) {
+	report := &ScrubReport{}
+
+	members := dp.Members()
+	unit := dp.buf.unit
+
+	ch := make(chan rwT)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		for i, reader := range members {
+			// without every member there is no redundancy to verify
+			if !reader.usable() {
+				return report, ErrNoRedundancy
+			}
+
+			reader.submit(OpRead, i, make([]byte, unit), ch)
+		}
+
+		results := make([]rwT, len(members))
+
+		var err error
+		for range members {
+			rc := <-ch
+
+			if rc.err != nil && rc.err != io.EOF && !errors.Is(rc.err, ErrChecksum) {
+				dp.state.set(DEGRADED)
+				err = rc.err
+			}
+
+			results[rc.idx] = rc
+		}
+
+		if err != nil {
+			return report, err
+		}
+
+		var size int
+		for _, rc := range results {
+			if !errors.Is(rc.err, ErrChecksum) && rc.n > size {
+				size = rc.n
+			}
+		}
+
+		var suspects []int
+		for i, rc := range results {
+			if errors.Is(rc.err, ErrChecksum) || rc.n < size {
+				suspects = append(suspects, i)
+			}
+		}
+
+		if size == 0 && len(suspects) == 0 {
+			return report, nil
+		}
+
+		switch len(suspects) {
+		case 0:
+			chunks := make(StripeBufferList, len(dp.stripe))
+			for i := range chunks {
+				chunks[i] = results[i].p[:size]
+			}
+
+			if !bytes.Equal(chunks.XOR(), results[len(dp.stripe)].p[:size]) {
+				report.Mismatches = append(report.Mismatches, Mismatch{report.Bytes, len(dp.stripe)})
+			}
+		case 1:
+			report.Mismatches = append(report.Mismatches, Mismatch{report.Bytes, suspects[0]})
+		default:
+			report.Mismatches = append(report.Mismatches, Mismatch{report.Bytes, -1})
+		}
+
+		report.Bytes += int64(size)
+	}
+}
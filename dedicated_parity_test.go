@@ -2,10 +2,15 @@ package streammux_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"io"
 	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/bh107/streammux"
 	"github.com/bh107/streammux/pkg/util/testutil"
@@ -20,11 +25,12 @@ func TestDedicatedParity(t *testing.T) {
 		testutil.NewFaultyDevice(1<<20, 100),
 	}
 
-	dp := streammux.NewDedicatedParity(blkdevs[0], blkdevs[1:])
+	dp := streammux.NewDedicatedParity(blkdevs[0], blkdevs[1:], streammux.WithStripeUnit(256))
 
 	dp.Open()
 
-	data := make([]byte, 1<<22)
+	// leave room for the superblock and stream trailer
+	data := make([]byte, 1<<22-1<<12)
 
 	f, err := os.Open("/dev/urandom")
 	if err != nil {
@@ -42,6 +48,8 @@ func TestDedicatedParity(t *testing.T) {
 
 	p := make([]byte, 1024)
 
+	failures := expectFailures(t, 3)
+
 	for {
 		_, err := buf.Read(p)
 		if err == io.EOF {
@@ -53,11 +61,11 @@ func TestDedicatedParity(t *testing.T) {
 		}
 
 		n, err := dp.Write(p)
-		if err != nil || n != len(p) {
-			t.Fatal(err, n)
-		}
+		failures.check(n, len(p), err)
 	}
 
+	failures.done()
+
 	// close to reset position
 	if err := dp.Close(); err != nil {
 		t.Fatal(err)
@@ -90,3 +98,359 @@ func TestDedicatedParity(t *testing.T) {
 		t.Fatal("origSha256Sum != newSha256Sum")
 	}
 }
+
+func TestDedicatedParityBufferSizes(t *testing.T) {
+	blkdevs := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 100),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	dp := streammux.NewDedicatedParity(blkdevs[0], blkdevs[1:], streammux.WithStripeUnit(4096))
+
+	dp.Open()
+
+	// leave room for the superblock and stream trailer
+	data := make([]byte, 1<<22-1<<12)
+
+	f, err := os.Open("/dev/urandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.Read(data)
+	if err != nil || n != len(data) {
+		t.Fatal(err)
+	}
+
+	origSha256Sum := sha256.Sum256(data)
+
+	buf := bytes.NewBuffer(data)
+
+	// write with a buffer size unrelated to the stripe unit
+	p := make([]byte, 1000)
+
+	failures := expectFailures(t, 1)
+
+	for {
+		n, err := buf.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := dp.Write(p[:n])
+		failures.check(m, n, err)
+	}
+
+	failures.done()
+
+	// close to reset position
+	if err := dp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen
+	dp.Open()
+
+	buf.Reset()
+
+	// and read it back with a different one
+	p = make([]byte, 40000)
+
+	for {
+		n, err := dp.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = buf.Write(p[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newSha256Sum := sha256.Sum256(buf.Bytes())
+
+	if origSha256Sum != newSha256Sum {
+		t.Fatal("origSha256Sum != newSha256Sum")
+	}
+}
+
+func TestDedicatedParityReplace(t *testing.T) {
+	blkdevs := make([]*testutil.BlockDevice, 5)
+	for i := range blkdevs {
+		blkdevs[i] = testutil.NewBlockDevice(1 << 20)
+	}
+
+	dp := newParityArray(blkdevs)
+
+	data := make([]byte, 1<<19+1000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	writeArray(t, dp, data)
+
+	readArray := func() {
+		got, err := io.ReadAll(dp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, got) {
+			t.Fatal("data read after rebuilding differs from the data written")
+		}
+	}
+
+	// rebuild a stripe member in the background
+	job := dp.ReplaceContext(context.Background(), 1, testutil.NewBlockDevice(1<<20))
+
+	// the array remains usable while rebuilding
+	dp.Open()
+
+	if dp.Health() != streammux.DEGRADED {
+		t.Fatal("expected the array to be DEGRADED while rebuilding")
+	}
+
+	readArray()
+	dp.Close()
+
+	<-job.Done()
+
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+
+	if state := dp.Members()[1].State(); state != streammux.OK {
+		t.Fatalf("expected the rebuilt member to be OK, got %v", state)
+	}
+
+	if dp.Health() != streammux.OK {
+		t.Fatal("expected the array to be OK after rebuilding")
+	}
+
+	readArray()
+	dp.Close()
+
+	// rebuild the parity member from the stripe, including the rebuilt member
+	job = dp.ReplaceContext(context.Background(), 4, testutil.NewBlockDevice(1<<20))
+	<-job.Done()
+
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	readArray()
+	dp.Close()
+
+	// wipe a stripe member such that it must be reconstructed from the
+	// rebuilt members
+	restore(t, blkdevs[1], make([]byte, 1<<20))
+
+	dp.Open()
+	defer dp.Close()
+
+	readArray()
+
+	if dp.Health() != streammux.DEGRADED {
+		t.Fatal("expected the wiped member to degrade the array")
+	}
+}
+
+func TestDedicatedParityRebuildResume(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "rebuild.journal")
+
+	blkdevs := make([]*testutil.BlockDevice, 5)
+	rwcs := make([]io.ReadWriteCloser, len(blkdevs))
+	for i := range blkdevs {
+		blkdevs[i] = testutil.NewBlockDevice(1 << 20)
+		rwcs[i] = blkdevs[i]
+	}
+
+	dst := testutil.NewBlockDevice(1 << 20)
+
+	dp := streammux.NewDedicatedParity(rwcs[4], rwcs[:4],
+		streammux.WithStripeUnit(4096),
+		streammux.WithRebuildRate(1<<19),
+		streammux.WithRebuildJournal(journal),
+	)
+
+	data := make([]byte, 1<<21)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	writeArray(t, dp, data)
+
+	// interrupt the rebuild about halfway through
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := dp.ReplaceContext(ctx, 1, dst)
+
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+
+	<-job.Done()
+
+	if _, err := os.Stat(journal); err != nil {
+		t.Fatal("expected a checkpoint to be kept for the interrupted rebuild")
+	}
+
+	// assemble the array again as if the process had been restarted
+	counting := &countingDevice{BlockDevice: dst}
+
+	arrays, err := streammux.Assemble([]io.ReadWriteCloser{rwcs[4], rwcs[3], rwcs[2], counting, rwcs[0]},
+		streammux.WithRebuildJournal(journal),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the assembled array is already open
+	assembled := arrays[0].(*streammux.DedicatedParity)
+
+	if assembled.Health() != streammux.DEGRADED {
+		t.Fatal("expected the array to be DEGRADED until the rebuild has finished")
+	}
+
+	assembled.Close()
+
+	job = assembled.Rebuild()
+	if job == nil {
+		t.Fatal("expected the interrupted rebuild to continue")
+	}
+
+	<-job.Done()
+
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if chunk := len(data) / 4; counting.written == 0 || counting.written >= chunk {
+		t.Fatalf("expected the rebuild to continue from the checkpoint, wrote %d bytes", counting.written)
+	}
+
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Fatal("expected the checkpoint to be removed after the rebuild")
+	}
+
+	// wipe a stripe member such that the data must be reconstructed with the
+	// rebuilt member
+	restore(t, blkdevs[2], make([]byte, 1<<20))
+
+	assembled.Open()
+	defer assembled.Close()
+
+	got, err := io.ReadAll(assembled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read with the rebuilt member differs from the data written")
+	}
+}
+
+// partialFaultDevice returns half of the requested bytes together with an
+// error on the read after failAfter reads have succeeded.
+type partialFaultDevice struct {
+	*testutil.BlockDevice
+
+	reads     int
+	failAfter int
+}
+
+func (d *partialFaultDevice) Read(p []byte) (int, error) {
+	d.reads++
+
+	if d.reads == d.failAfter+1 {
+		n, _ := d.BlockDevice.Read(p[:len(p)/2])
+		return n, syscall.EIO
+	}
+
+	return d.BlockDevice.Read(p)
+}
+
+func TestDedicatedParityPartialReadFailure(t *testing.T) {
+	faulty := &partialFaultDevice{BlockDevice: testutil.NewBlockDevice(1 << 20), failAfter: -1}
+
+	dp := streammux.NewDedicatedParity(testutil.NewBlockDevice(1<<20), []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		faulty,
+		testutil.NewBlockDevice(1 << 20),
+	}, streammux.WithStripeUnit(512))
+
+	data := make([]byte, 1<<16)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	writeArray(t, dp, data)
+
+	// the bytes returned with the failure are not counted on top of the
+	// reconstructed chunk
+	faulty.reads, faulty.failAfter = 0, 10
+
+	dp.Open()
+	defer dp.Close()
+
+	if got := readAll(t, dp, 1536); !bytes.Equal(data, got) {
+		t.Fatalf("expected the failed chunk to be reconstructed, read %d of %d bytes", len(got), len(data))
+	}
+
+	if dp.Health() != streammux.DEGRADED {
+		t.Fatal("expected the failed member to degrade the array")
+	}
+}
+
+func TestDedicatedParityAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("buffers are not reused with the race detector")
+	}
+
+	dp := newBenchDedicatedParity(blockDevices(4))
+	p := make([]byte, 1<<20)
+
+	// the first write of a stream writes the superblocks
+	dp.Open()
+
+	if allocs := testing.AllocsPerRun(4, func() {
+		if _, err := dp.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}); allocs != 0 {
+		t.Fatalf("expected writes not to allocate, got %v allocations", allocs)
+	}
+
+	if err := dp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first read of a stream reads the superblocks
+	dp.Open()
+	defer dp.Close()
+
+	if allocs := testing.AllocsPerRun(4, func() {
+		if _, err := dp.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}); allocs != 0 {
+		t.Fatalf("expected reads not to allocate, got %v allocations", allocs)
+	}
+}
@@ -0,0 +1,360 @@
+package streammux
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// DistributedParity is a redundancy behavior where the parity chunk rotates
+// across all members stripe by stripe, similar to RAID-5.
+type DistributedParity struct {
+	sync.Mutex
+
+	ios []*Member
+	buf *striper
+
+	// stripe sequence numbers for reading and writing
+	rseq int
+	wseq int
+
+	// the array identity shared by the members
+	array *array
+
+	// the reads and writes in flight on the members
+	ahead *readahead
+	pipe  *pipeline
+
+	state health
+}
+
+// NewDistributedParity returns a DistributedParity of rwcs. Media holding an
+// array of the same geometry is adopted when opened and blank media is
+// formatted.
+func NewDistributedParity(rwcs []io.ReadWriteCloser, opts ...MemberOption) *DistributedParity {
+	dp := &DistributedParity{
+		ios: make([]*Member, len(rwcs)),
+	}
+
+	for i, rwc := range rwcs {
+		dp.ios[i] = NewMember(rwc, opts...)
+	}
+
+	o := newMemberOptions(opts)
+	dp.ahead = newReadahead(o.readAhead, len(rwcs), dp.issueRead, dp.completeRead)
+	dp.buf = newStriper(o.stripeUnit, len(rwcs)-1, dp.ahead.read, dp.writeStripe)
+
+	dp.array = attach(dp, KindDistributedParity, len(rwcs)-1, o.stripeUnit, dp.ios)
+	dp.pipe = newPipeline(o.queueDepth, len(rwcs), dp.completeWrite)
+
+	return dp
+}
+
+func (dp *DistributedParity) Health() State {
+	return dp.state.get()
+}
+
+// Members returns the members of the behavior.
+func (dp *DistributedParity) Members() []*Member {
+	return dp.ios
+}
+
+func (dp *DistributedParity) Open() State {
+	dp.Lock()
+
+	dp.rseq, dp.wseq = 0, 0
+
+	// reset state
+	dp.state.set(OK)
+	dp.buf.reset()
+
+	var numFailed int
+
+	for _, rwc := range dp.ios {
+		state := rwc.Open()
+		switch state {
+		case FAILED:
+			numFailed++
+			if numFailed > 1 {
+				dp.state.set(FAILED)
+			} else {
+				dp.state.set(DEGRADED)
+			}
+		case DEGRADED:
+			if dp.state.get() == FAILED {
+				break
+			}
+
+			dp.state.set(DEGRADED)
+		}
+	}
+
+	dp.array.observe()
+
+	return dp.state.get()
+}
+
+func (dp *DistributedParity) Close() (err error) {
+	defer dp.Unlock()
+
+	flushErr := dp.buf.flush()
+
+	// wait for the queued writes, including the final stripe
+	if perr := dp.pipe.flush(); flushErr == nil {
+		flushErr = perr
+	}
+
+	dp.ahead.reset()
+
+	for _, closer := range dp.ios {
+		err = closer.Close()
+	}
+
+	if flushErr != nil {
+		err = flushErr
+	}
+
+	return
+}
+
+// parityIndex returns the index of the member holding the parity chunk of
+// stripe seq.
+func (dp *DistributedParity) parityIndex(seq int) int {
+	return len(dp.ios) - 1 - seq%len(dp.ios)
+}
+
+// memberIndex returns the index of the member holding data chunk i of the
+// stripe with parity on member pidx. Data chunks start on the member
+// following the parity member and wrap around.
+func (dp *DistributedParity) memberIndex(pidx, i int) int {
+	return (pidx + 1 + i) % len(dp.ios)
+}
+
+// chunkIndex returns the data chunk held by the member at idx of the stripe
+// with parity on member pidx. It is the inverse of memberIndex.
+func (dp *DistributedParity) chunkIndex(pidx, idx int) int {
+	return (idx - pidx - 1 + len(dp.ios)) % len(dp.ios)
+}
+
+// fail marks the member at idx as FAILED and degrades the array. The array
+// fails when more than one member has failed. The failed members are counted,
+// as a member that failed an operation has usually marked itself FAILED
+// already.
+func (dp *DistributedParity) fail(idx int) {
+	dp.ios[idx].SetState(FAILED)
+
+	var numFailed int
+	for _, m := range dp.ios {
+		if m.State() == FAILED {
+			numFailed++
+		}
+	}
+
+	if numFailed > 1 {
+		dp.state.set(FAILED)
+	} else {
+		dp.state.set(DEGRADED)
+	}
+}
+
+func (dp *DistributedParity) Read(p []byte) (n int, err error) {
+	defer dp.array.observe()
+
+	return dp.buf.read(p)
+}
+
+func (dp *DistributedParity) Write(p []byte) (n int, err error) {
+	defer dp.array.observe()
+
+	return dp.buf.write(p)
+}
+
+// issueRead submits the reads of a single stripe into r.p. The member holding
+// the parity of the stripe is recorded in r.idx.
+func (dp *DistributedParity) issueRead(r *inflight) error {
+	if dp.state.get() == FAILED {
+		return ErrArrayFailed
+	}
+
+	stripe, err := split(r.p, len(dp.ios)-1)
+	if err != nil {
+		return err
+	}
+
+	pidx := dp.parityIndex(dp.rseq)
+	dp.rseq++
+
+	r.idx = pidx
+
+	// the parity chunk is only needed for reconstruction and is read into a
+	// buffer from the pool
+	parity := getBuffer(len(stripe[0]))
+	r.hold(parity)
+
+	for i, reader := range dp.ios {
+		if !reader.usable() {
+			continue
+		}
+
+		// data chunks are read directly into their part of p
+		buf := *parity
+		if i != pidx {
+			buf = stripe[dp.chunkIndex(pidx, i)]
+		}
+
+		r.fetch(reader, i, buf)
+	}
+
+	return nil
+}
+
+// completeRead collects the results of a read issued by issueRead and
+// reconstructs a missing data chunk.
+func (dp *DistributedParity) completeRead(r *inflight) (n int, err error) {
+	width := len(dp.ios) - 1
+	stripe, _ := split(r.p, width)
+
+	pidx := r.idx
+
+	// the stripe as laid out on the members (data chunks and parity)
+	chunks := make(StripeBufferList, len(dp.ios))
+
+	// the number of bytes read from each member and whether any hit EOF
+	size := len(stripe[0])
+	var eof bool
+
+	for i := 0; i < r.active; i++ {
+		rc := <-r.results
+
+		// a corrupt chunk is reconstructed like a missing one, but the
+		// member remains usable
+		if errors.Is(rc.err, ErrChecksum) {
+			continue
+		}
+
+		if rc.err != nil && rc.err != io.EOF {
+			dp.fail(rc.idx)
+			err = rc.err
+
+			continue
+		}
+
+		if rc.err == io.EOF {
+			eof = true
+		}
+
+		if rc.n < size {
+			size = rc.n
+		}
+
+		chunks[rc.idx] = rc.p
+	}
+
+	if dp.state.get() == FAILED {
+		return 0, err
+	}
+
+	if chunks.missing() > 1 {
+		dp.state.set(FAILED)
+		return 0, ErrArrayFailed
+	}
+
+	if dp.state.get() == OK && anyDegraded(dp.ios) {
+		dp.state.set(DEGRADED)
+	}
+
+	if eof && size == 0 {
+		return 0, io.EOF
+	}
+
+	for i := range chunks {
+		if chunks[i] != nil {
+			chunks[i] = chunks[i][:size]
+		}
+	}
+
+	// reconstruct a missing data chunk from the remaining chunks
+	for i := 0; i < width; i++ {
+		idx := dp.memberIndex(pidx, i)
+		if chunks[idx] != nil {
+			continue
+		}
+
+		survivors := make(StripeBufferList, 0, width)
+		for j, buf := range chunks {
+			if j != idx {
+				survivors = append(survivors, buf)
+			}
+		}
+
+		survivors.XORInto(stripe[i][:size])
+
+		// at most one member can be missing
+		break
+	}
+
+	return size * width, nil
+}
+
+// writeStripe writes p as a single stripe and its parity.
+func (dp *DistributedParity) writeStripe(p []byte) (n int, err error) {
+	if dp.state.get() == FAILED {
+		return 0, ErrArrayFailed
+	}
+
+	if len(p)%(len(dp.ios)-1) != 0 {
+		return 0, errStripeWidth
+	}
+
+	w, p := dp.pipe.begin(p)
+
+	stripe, _ := split(p, len(dp.ios)-1)
+
+	pidx := dp.parityIndex(dp.wseq)
+	dp.wseq++
+
+	for i, writer := range dp.ios {
+		if i == pidx || !writer.usable() {
+			continue
+		}
+
+		w.submit(writer, i, stripe[dp.chunkIndex(pidx, i)])
+	}
+
+	// the parity is computed while the data chunks are written
+	if writer := dp.ios[pidx]; writer.usable() {
+		parity := getBuffer(len(stripe[0]))
+		w.hold(parity)
+
+		stripe.XORInto(*parity)
+
+		w.submit(writer, pidx, *parity)
+	}
+
+	return dp.pipe.end(w)
+}
+
+// completeWrite collects the results of a write issued by writeStripe.
+func (dp *DistributedParity) completeWrite(w *inflight) (n int, err error) {
+	var failures MemberErrors
+
+	for i := 0; i < w.active; i++ {
+		rc := <-w.results
+
+		if rc.err != nil && rc.err != io.EOF {
+			dp.fail(rc.idx)
+			failures.add(rc.err)
+			err = rc.err
+		}
+	}
+
+	if dp.state.get() == FAILED {
+		return 0, failures.join(err)
+	}
+
+	if dp.state.get() == OK && anyDegraded(dp.ios) {
+		dp.state.set(DEGRADED)
+	}
+
+	return w.size, failures.survived()
+}
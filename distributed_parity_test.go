@@ -0,0 +1,134 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+func TestDistributedParity(t *testing.T) {
+	blkdevs := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 100),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	dp := streammux.NewDistributedParity(blkdevs, streammux.WithStripeUnit(256))
+
+	dp.Open()
+
+	// leave room for the superblock and stream trailer
+	data := make([]byte, 1<<22-1<<12)
+
+	f, err := os.Open("/dev/urandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.Read(data)
+	if err != nil || n != len(data) {
+		t.Fatal(err)
+	}
+
+	origSha256Sum := sha256.Sum256(data)
+
+	buf := bytes.NewBuffer(data)
+
+	p := make([]byte, 1024)
+
+	failures := expectFailures(t, 2)
+
+	for {
+		_, err := buf.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := dp.Write(p)
+		failures.check(n, len(p), err)
+	}
+
+	failures.done()
+
+	// close to reset position
+	if err := dp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen
+	dp.Open()
+
+	buf.Reset()
+
+	for {
+		_, err := dp.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = buf.Write(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newSha256Sum := sha256.Sum256(buf.Bytes())
+
+	if origSha256Sum != newSha256Sum {
+		t.Fatal("origSha256Sum != newSha256Sum")
+	}
+}
+
+func TestDistributedParityMemberFailure(t *testing.T) {
+	dp := streammux.NewDistributedParity([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 10),
+		testutil.NewBlockDevice(1 << 20),
+	}, streammux.WithStripeUnit(512))
+
+	data := make([]byte, 1<<16)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+
+	// the member has already failed itself when the array learns of it,
+	// which must still degrade the array
+	if _, err := dp.Write(data); errors.Is(err, streammux.ErrArrayFailed) {
+		t.Fatal(err)
+	}
+
+	if err := dp.Close(); errors.Is(err, streammux.ErrArrayFailed) {
+		t.Fatal(err)
+	}
+
+	if dp.Health() != streammux.DEGRADED || dp.Members()[2].State() != streammux.FAILED {
+		t.Fatal("expected the member to fail and the array to be DEGRADED")
+	}
+
+	dp.Open()
+	defer dp.Close()
+
+	if got := readAll(t, dp, 4096); !bytes.Equal(data, got) {
+		t.Fatal("data reconstructed without the failed member differs from the data written")
+	}
+}
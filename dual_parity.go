@@ -0,0 +1,428 @@
+package streammux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxDualParityStripe is the largest number of stripe members of a
+// DualParity. The Q syndrome weighs stripe member i with g^i, which repeats
+// after the 255 nonzero elements of GF(2^8).
+const maxDualParityStripe = 255
+
+// DualParity is a redundancy behavior with a stripe and two dedicated parity
+// devices similar to RAID-6. The P parity is the XOR of the stripe and the Q
+// parity is a Reed-Solomon syndrome over GF(2^8), which allows any two
+// members to fail.
+type DualParity struct {
+	sync.Mutex
+
+	// the stripe members followed by the P and the Q member
+	ios []*Member
+	buf *striper
+
+	// the array identity shared by the members
+	array *array
+
+	// the reads and writes in flight on the members
+	ahead *readahead
+	pipe  *pipeline
+
+	state health
+}
+
+// NewDualParity returns a DualParity of the stripe members and the parity
+// members p and q. Media holding an array of the same geometry is adopted
+// when opened and blank media is formatted. There must be between 1 and 255
+// stripe members.
+func NewDualParity(p, q io.ReadWriteCloser, stripe []io.ReadWriteCloser, opts ...MemberOption) (*DualParity, error) {
+	if len(stripe) < 1 || len(stripe) > maxDualParityStripe {
+		return nil, fmt.Errorf("%w: %d stripe members", errGeometry, len(stripe))
+	}
+
+	dp := &DualParity{
+		ios: make([]*Member, len(stripe)+2),
+	}
+
+	for i, rwc := range stripe {
+		dp.ios[i] = NewMember(rwc, opts...)
+	}
+
+	dp.ios[len(stripe)] = NewMember(p, opts...)
+	dp.ios[len(stripe)+1] = NewMember(q, opts...)
+
+	o := newMemberOptions(opts)
+	dp.ahead = newReadahead(o.readAhead, len(stripe)+2, dp.issueRead, dp.completeRead)
+	dp.buf = newStriper(o.stripeUnit, len(stripe), dp.ahead.read, dp.writeStripe)
+
+	dp.array = attach(dp, KindDualParity, len(stripe), o.stripeUnit, dp.ios)
+	dp.pipe = newPipeline(o.queueDepth, len(stripe)+2, dp.completeWrite)
+
+	return dp, nil
+}
+
+func (dp *DualParity) Health() State {
+	return dp.state.get()
+}
+
+// Members returns the members of the behavior.
+func (dp *DualParity) Members() []*Member {
+	return dp.ios
+}
+
+func (dp *DualParity) Open() State {
+	dp.Lock()
+
+	// reset state
+	dp.state.set(OK)
+	dp.buf.reset()
+
+	var numFailed int
+
+	for _, rwc := range dp.ios {
+		state := rwc.Open()
+		switch state {
+		case FAILED:
+			numFailed++
+			if numFailed > 2 {
+				dp.state.set(FAILED)
+			} else {
+				dp.state.set(DEGRADED)
+			}
+		case DEGRADED:
+			if dp.state.get() == FAILED {
+				break
+			}
+
+			dp.state.set(DEGRADED)
+		}
+	}
+
+	dp.array.observe()
+
+	return dp.state.get()
+}
+
+func (dp *DualParity) Close() (err error) {
+	defer dp.Unlock()
+
+	flushErr := dp.buf.flush()
+
+	// wait for the queued writes, including the final stripe
+	if perr := dp.pipe.flush(); flushErr == nil {
+		flushErr = perr
+	}
+
+	dp.ahead.reset()
+
+	for _, closer := range dp.ios {
+		err = closer.Close()
+	}
+
+	if flushErr != nil {
+		err = flushErr
+	}
+
+	return
+}
+
+// fail marks the member at idx as FAILED and degrades the array. The array
+// fails when more than two members have failed.
+func (dp *DualParity) fail(idx int) {
+	dp.ios[idx].SetState(FAILED)
+
+	var numFailed int
+	for _, m := range dp.ios {
+		if m.State() == FAILED {
+			numFailed++
+		}
+	}
+
+	if numFailed > 2 {
+		dp.state.set(FAILED)
+	} else {
+		dp.state.set(DEGRADED)
+	}
+}
+
+// syndromes computes the P and Q syndromes of stripe into p and q.
+func syndromes(stripe StripeBufferList, p, q StripeBuffer) {
+	stripe.XORInto(p)
+
+	gfMulSlice(q, stripe[0], gfPow(0))
+	for i := 1; i < len(stripe); i++ {
+		gfMulAddSlice(q, stripe[i], gfPow(i))
+	}
+}
+
+// reconstructPQ fills in the missing (nil) chunks of chunks, which holds the
+// data chunks of a stripe followed by the P and Q chunks. At most two chunks
+// may be missing.
+func reconstructPQ(chunks StripeBufferList) {
+	k := len(chunks) - 2
+	pidx, qidx := k, k+1
+
+	var size int
+	var missing []int
+
+	for i, buf := range chunks {
+		if buf == nil {
+			missing = append(missing, i)
+			continue
+		}
+
+		size = len(buf)
+	}
+
+	// the data chunks that are missing
+	var x, y = -1, -1
+	for _, idx := range missing {
+		if idx >= k {
+			continue
+		}
+
+		if x == -1 {
+			x = idx
+		} else {
+			y = idx
+		}
+	}
+
+	if x == -1 {
+		// only parity is missing, nothing to do for the data
+		return
+	}
+
+	// partial syndromes over the data chunks that are present
+	pxy := make(StripeBuffer, size)
+	qxy := make(StripeBuffer, size)
+
+	for i, buf := range chunks[:k] {
+		if buf == nil {
+			continue
+		}
+
+		xorWords(pxy, pxy, buf)
+		gfMulAddSlice(qxy, buf, gfPow(i))
+	}
+
+	switch {
+	case y == -1 && chunks[pidx] != nil:
+		// D_x = P + Pxy
+		xorWords(pxy, pxy, chunks[pidx])
+		chunks[x] = pxy
+
+	case y == -1:
+		// D_x = (Q + Qxy) * g^-x
+		xorWords(qxy, qxy, chunks[qidx])
+		gfMulSlice(qxy, qxy, gfPow(-x))
+		chunks[x] = qxy
+
+	default:
+		// Pxy = D_x + D_y and Qxy = g^x D_x + g^y D_y, which gives
+		// D_x = A*Pxy + B*Qxy with A = g^(y-x) / (g^(y-x) + 1) and
+		// B = g^-x / (g^(y-x) + 1).
+		xorWords(pxy, pxy, chunks[pidx])
+		xorWords(qxy, qxy, chunks[qidx])
+
+		gyx := gfPow(y - x)
+		denom := gyx ^ 1
+		a := gfDiv(gyx, denom)
+		b := gfDiv(gfPow(-x), denom)
+
+		dx := make(StripeBuffer, size)
+		gfMulSlice(dx, pxy, a)
+		gfMulAddSlice(dx, qxy, b)
+
+		xorWords(pxy, pxy, dx)
+
+		chunks[x] = dx
+		chunks[y] = pxy
+	}
+}
+
+func (dp *DualParity) Read(p []byte) (n int, err error) {
+	defer dp.array.observe()
+
+	return dp.buf.read(p)
+}
+
+func (dp *DualParity) Write(p []byte) (n int, err error) {
+	defer dp.array.observe()
+
+	return dp.buf.write(p)
+}
+
+// issueRead submits the reads of a single stripe into r.p.
+func (dp *DualParity) issueRead(r *inflight) error {
+	if dp.state.get() == FAILED {
+		return ErrArrayFailed
+	}
+
+	k := len(dp.ios) - 2
+	stripe, err := split(r.p, k)
+	if err != nil {
+		return err
+	}
+
+	// the parity chunks are only needed for reconstruction and are read into
+	// buffers from the pool
+	pbuf, qbuf := getBuffer(len(stripe[0])), getBuffer(len(stripe[0]))
+	r.hold(pbuf)
+	r.hold(qbuf)
+
+	for i, reader := range dp.ios {
+		if !reader.usable() {
+			continue
+		}
+
+		// data chunks are read directly into their part of p
+		var buf []byte
+		switch i {
+		case k:
+			buf = *pbuf
+		case k + 1:
+			buf = *qbuf
+		default:
+			buf = stripe[i]
+		}
+
+		r.fetch(reader, i, buf)
+	}
+
+	return nil
+}
+
+// completeRead collects the results of a read issued by issueRead and
+// reconstructs missing data chunks.
+func (dp *DualParity) completeRead(r *inflight) (n int, err error) {
+	k := len(dp.ios) - 2
+	stripe, _ := split(r.p, k)
+
+	chunks := make(StripeBufferList, len(dp.ios))
+
+	// the number of bytes read from each member and whether any hit EOF
+	size := len(stripe[0])
+	var eof bool
+
+	for i := 0; i < r.active; i++ {
+		rc := <-r.results
+
+		// a corrupt chunk is reconstructed like a missing one, but the
+		// member remains usable
+		if errors.Is(rc.err, ErrChecksum) {
+			continue
+		}
+
+		if rc.err != nil && rc.err != io.EOF {
+			dp.fail(rc.idx)
+			err = rc.err
+
+			continue
+		}
+
+		if rc.err == io.EOF {
+			eof = true
+		}
+
+		if rc.n < size {
+			size = rc.n
+		}
+
+		chunks[rc.idx] = rc.p
+	}
+
+	if dp.state.get() == FAILED {
+		return 0, err
+	}
+
+	if chunks.missing() > 2 {
+		dp.state.set(FAILED)
+		return 0, ErrArrayFailed
+	}
+
+	if dp.state.get() == OK && anyDegraded(dp.ios) {
+		dp.state.set(DEGRADED)
+	}
+
+	if eof && size == 0 {
+		return 0, io.EOF
+	}
+
+	for i := range chunks {
+		if chunks[i] != nil {
+			chunks[i] = chunks[i][:size]
+		}
+	}
+
+	// missing data chunks are reconstructed into buffers of their own
+	if chunks[:k].missing() > 0 {
+		reconstructPQ(chunks)
+
+		for i := 0; i < k; i++ {
+			copy(stripe[i], chunks[i])
+		}
+	}
+
+	return size * k, nil
+}
+
+// writeStripe writes p as a single stripe and its parity.
+func (dp *DualParity) writeStripe(p []byte) (n int, err error) {
+	if dp.state.get() == FAILED {
+		return 0, ErrArrayFailed
+	}
+
+	k := len(dp.ios) - 2
+	if len(p)%k != 0 {
+		return 0, errStripeWidth
+	}
+
+	w, p := dp.pipe.begin(p)
+
+	stripe, _ := split(p, k)
+
+	pbuf, qbuf := getBuffer(len(stripe[0])), getBuffer(len(stripe[0]))
+	w.hold(pbuf)
+	w.hold(qbuf)
+
+	syndromes(stripe, *pbuf, *qbuf)
+	chunks := append(stripe, *pbuf, *qbuf)
+
+	for i, writer := range dp.ios {
+		if !writer.usable() {
+			continue
+		}
+
+		w.submit(writer, i, chunks[i])
+	}
+
+	return dp.pipe.end(w)
+}
+
+// completeWrite collects the results of a write issued by writeStripe.
+func (dp *DualParity) completeWrite(w *inflight) (n int, err error) {
+	var failures MemberErrors
+
+	for i := 0; i < w.active; i++ {
+		rc := <-w.results
+
+		if rc.err != nil && rc.err != io.EOF {
+			dp.fail(rc.idx)
+			failures.add(rc.err)
+			err = rc.err
+		}
+	}
+
+	if dp.state.get() == FAILED {
+		return 0, failures.join(err)
+	}
+
+	if dp.state.get() == OK && anyDegraded(dp.ios) {
+		dp.state.set(DEGRADED)
+	}
+
+	return w.size, failures.survived()
+}
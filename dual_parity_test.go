@@ -0,0 +1,137 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+func TestDualParity(t *testing.T) {
+	blkdevs := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 300),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 100),
+	}
+
+	dp := newDualParity(t, blkdevs[0], blkdevs[1], blkdevs[2:], streammux.WithStripeUnit(256))
+
+	dp.Open()
+
+	// leave room for the superblock and stream trailer
+	data := make([]byte, 1<<22-1<<12)
+
+	f, err := os.Open("/dev/urandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.Read(data)
+	if err != nil || n != len(data) {
+		t.Fatal(err)
+	}
+
+	origSha256Sum := sha256.Sum256(data)
+
+	buf := bytes.NewBuffer(data)
+
+	p := make([]byte, 1024)
+
+	failures := expectFailures(t, 3, 1)
+
+	for {
+		_, err := buf.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := dp.Write(p)
+		failures.check(n, len(p), err)
+	}
+
+	failures.done()
+
+	if dp.Health() != streammux.DEGRADED {
+		t.Fatal("expected two failed members to degrade the array")
+	}
+
+	// close to reset position
+	if err := dp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen
+	dp.Open()
+
+	buf.Reset()
+
+	for {
+		_, err := dp.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = buf.Write(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newSha256Sum := sha256.Sum256(buf.Bytes())
+
+	if origSha256Sum != newSha256Sum {
+		t.Fatal("origSha256Sum != newSha256Sum")
+	}
+}
+
+// newDualParity returns a DualParity of the stripe members and the parity
+// members p and q, failing the test if the geometry is invalid.
+func newDualParity(t testing.TB, p, q io.ReadWriteCloser, stripe []io.ReadWriteCloser, opts ...streammux.MemberOption) *streammux.DualParity {
+	dp, err := streammux.NewDualParity(p, q, stripe, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dp
+}
+
+func TestDualParityGeometry(t *testing.T) {
+	devices := func(n int) []io.ReadWriteCloser {
+		rwcs := make([]io.ReadWriteCloser, n)
+		for i := range rwcs {
+			rwcs[i] = testutil.NewBlockDevice(1 << 12)
+		}
+
+		return rwcs
+	}
+
+	p, q := testutil.NewBlockDevice(1<<12), testutil.NewBlockDevice(1<<12)
+
+	if _, err := streammux.NewDualParity(p, q, nil); err == nil {
+		t.Fatal("expected an error without stripe members")
+	}
+
+	// the Q syndrome weighs the stripe members with the 255 powers of g
+	if _, err := streammux.NewDualParity(p, q, devices(256)); err == nil {
+		t.Fatal("expected an error for more than 255 stripe members")
+	}
+
+	if _, err := streammux.NewDualParity(p, q, devices(255)); err != nil {
+		t.Fatal(err)
+	}
+}
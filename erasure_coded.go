@@ -0,0 +1,331 @@
+package streammux
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErasureCoded is a redundancy behavior with k data members and m coding
+// members using a systematic Reed-Solomon code. Any m members may fail.
+//
+// Mirror (k = 1) and DedicatedParity (m = 1) are special cases; with m = 1
+// the coding member holds the XOR parity of the stripe and with k = 1 every
+// coding member holds a copy of the data.
+type ErasureCoded struct {
+	sync.Mutex
+
+	// the data members followed by the coding members
+	ios []*Member
+	buf *striper
+
+	k, m int
+	enc  gfMatrix
+
+	// the array identity shared by the members
+	array *array
+
+	// the reads and writes in flight on the members
+	ahead *readahead
+	pipe  *pipeline
+
+	state health
+}
+
+// NewErasureCoded returns an ErasureCoded array of the data and coding
+// members. Media holding an array of the same geometry is adopted when
+// opened and blank media is formatted. There must be at least one data member
+// and no more than 256 members in all.
+func NewErasureCoded(data, coding []io.ReadWriteCloser, opts ...MemberOption) (*ErasureCoded, error) {
+	enc, err := newCodingMatrix(len(data), len(coding))
+	if err != nil {
+		return nil, err
+	}
+
+	ec := &ErasureCoded{
+		ios: make([]*Member, len(data)+len(coding)),
+		k:   len(data),
+		m:   len(coding),
+		enc: enc,
+	}
+
+	for i, rwc := range data {
+		ec.ios[i] = NewMember(rwc, opts...)
+	}
+
+	for i, rwc := range coding {
+		ec.ios[len(data)+i] = NewMember(rwc, opts...)
+	}
+
+	o := newMemberOptions(opts)
+	ec.ahead = newReadahead(o.readAhead, len(data)+len(coding), ec.issueRead, ec.completeRead)
+	ec.buf = newStriper(o.stripeUnit, len(data), ec.ahead.read, ec.writeStripe)
+
+	ec.array = attach(ec, KindErasureCoded, len(data), o.stripeUnit, ec.ios)
+	ec.pipe = newPipeline(o.queueDepth, len(data)+len(coding), ec.completeWrite)
+
+	return ec, nil
+}
+
+func (ec *ErasureCoded) Health() State {
+	return ec.state.get()
+}
+
+// Members returns the members of the behavior.
+func (ec *ErasureCoded) Members() []*Member {
+	return ec.ios
+}
+
+func (ec *ErasureCoded) Open() State {
+	ec.Lock()
+
+	// reset state
+	ec.state.set(OK)
+	ec.buf.reset()
+
+	var numFailed int
+
+	for _, rwc := range ec.ios {
+		state := rwc.Open()
+		switch state {
+		case FAILED:
+			numFailed++
+			if numFailed > ec.m {
+				ec.state.set(FAILED)
+			} else {
+				ec.state.set(DEGRADED)
+			}
+		case DEGRADED:
+			if ec.state.get() == FAILED {
+				break
+			}
+
+			ec.state.set(DEGRADED)
+		}
+	}
+
+	ec.array.observe()
+
+	return ec.state.get()
+}
+
+func (ec *ErasureCoded) Close() (err error) {
+	defer ec.Unlock()
+
+	flushErr := ec.buf.flush()
+
+	// wait for the queued writes, including the final stripe
+	if perr := ec.pipe.flush(); flushErr == nil {
+		flushErr = perr
+	}
+
+	ec.ahead.reset()
+
+	for _, closer := range ec.ios {
+		err = closer.Close()
+	}
+
+	if flushErr != nil {
+		err = flushErr
+	}
+
+	return
+}
+
+// fail marks the member at idx as FAILED and degrades the array. The array
+// fails when more than m members have failed.
+func (ec *ErasureCoded) fail(idx int) {
+	ec.ios[idx].SetState(FAILED)
+
+	var numFailed int
+	for _, m := range ec.ios {
+		if m.State() == FAILED {
+			numFailed++
+		}
+	}
+
+	if numFailed > ec.m {
+		ec.state.set(FAILED)
+	} else {
+		ec.state.set(DEGRADED)
+	}
+}
+
+func (ec *ErasureCoded) Read(p []byte) (n int, err error) {
+	defer ec.array.observe()
+
+	return ec.buf.read(p)
+}
+
+func (ec *ErasureCoded) Write(p []byte) (n int, err error) {
+	defer ec.array.observe()
+
+	return ec.buf.write(p)
+}
+
+// issueRead submits the reads of a single stripe into r.p.
+func (ec *ErasureCoded) issueRead(r *inflight) error {
+	if ec.state.get() == FAILED {
+		return ErrArrayFailed
+	}
+
+	stripe, err := split(r.p, ec.k)
+	if err != nil {
+		return err
+	}
+
+	for i, reader := range ec.ios {
+		if !reader.usable() {
+			continue
+		}
+
+		// data chunks are read directly into their part of p, the coding
+		// chunks are only needed for reconstruction and are read into
+		// buffers from the pool
+		if i < ec.k {
+			r.fetch(reader, i, stripe[i])
+		} else {
+			buf := getBuffer(len(stripe[0]))
+			r.hold(buf)
+
+			r.fetch(reader, i, *buf)
+		}
+	}
+
+	return nil
+}
+
+// completeRead collects the results of a read issued by issueRead and
+// reconstructs missing data chunks.
+func (ec *ErasureCoded) completeRead(r *inflight) (n int, err error) {
+	stripe, _ := split(r.p, ec.k)
+
+	chunks := make(StripeBufferList, len(ec.ios))
+
+	// the number of bytes read from each member and whether any hit EOF
+	size := len(stripe[0])
+	var eof bool
+
+	for i := 0; i < r.active; i++ {
+		rc := <-r.results
+
+		// a corrupt chunk is reconstructed like a missing one, but the
+		// member remains usable
+		if errors.Is(rc.err, ErrChecksum) {
+			continue
+		}
+
+		if rc.err != nil && rc.err != io.EOF {
+			ec.fail(rc.idx)
+			err = rc.err
+
+			continue
+		}
+
+		if rc.err == io.EOF {
+			eof = true
+		}
+
+		if rc.n < size {
+			size = rc.n
+		}
+
+		chunks[rc.idx] = rc.p
+	}
+
+	if ec.state.get() == FAILED {
+		return 0, err
+	}
+
+	if ec.state.get() == OK && anyDegraded(ec.ios) {
+		ec.state.set(DEGRADED)
+	}
+
+	if eof && size == 0 {
+		return 0, io.EOF
+	}
+
+	for i := range chunks {
+		if chunks[i] != nil {
+			chunks[i] = chunks[i][:size]
+		}
+	}
+
+	// missing data chunks are reconstructed into buffers of their own
+	if chunks[:ec.k].missing() > 0 {
+		if err := ec.enc.reconstruct(chunks); err != nil {
+			ec.state.set(FAILED)
+			return 0, ErrArrayFailed
+		}
+
+		for i := 0; i < ec.k; i++ {
+			copy(stripe[i], chunks[i])
+		}
+	}
+
+	return size * ec.k, nil
+}
+
+// writeStripe writes p as a single stripe and its coding chunks.
+func (ec *ErasureCoded) writeStripe(p []byte) (n int, err error) {
+	if ec.state.get() == FAILED {
+		return 0, ErrArrayFailed
+	}
+
+	if len(p)%ec.k != 0 {
+		return 0, errStripeWidth
+	}
+
+	w, p := ec.pipe.begin(p)
+
+	stripe, _ := split(p, ec.k)
+
+	// the coding chunks are held by the write, as it may still be in flight
+	// when the next stripe is encoded
+	coding := make(StripeBufferList, ec.m)
+	for i := range coding {
+		buf := getBuffer(len(stripe[0]))
+		w.hold(buf)
+
+		coding[i] = *buf
+	}
+
+	ec.enc.encode(stripe, coding)
+
+	chunks := append(stripe, coding...)
+
+	for i, writer := range ec.ios {
+		if !writer.usable() {
+			continue
+		}
+
+		w.submit(writer, i, chunks[i])
+	}
+
+	return ec.pipe.end(w)
+}
+
+// completeWrite collects the results of a write issued by writeStripe.
+func (ec *ErasureCoded) completeWrite(w *inflight) (n int, err error) {
+	var failures MemberErrors
+
+	for i := 0; i < w.active; i++ {
+		rc := <-w.results
+
+		if rc.err != nil && rc.err != io.EOF {
+			ec.fail(rc.idx)
+			failures.add(rc.err)
+			err = rc.err
+		}
+	}
+
+	if ec.state.get() == FAILED {
+		return 0, failures.join(err)
+	}
+
+	if ec.state.get() == OK && anyDegraded(ec.ios) {
+		ec.state.set(DEGRADED)
+	}
+
+	return w.size, failures.survived()
+}
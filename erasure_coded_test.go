@@ -0,0 +1,139 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+func TestErasureCoded(t *testing.T) {
+	blkdevs := []io.ReadWriteCloser{
+		testutil.NewFaultyDevice(1<<20, 500),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 100),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 300),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	ec, err := streammux.NewErasureCoded(blkdevs[:4], blkdevs[4:], streammux.WithStripeUnit(256))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec.Open()
+
+	// leave room for the superblock and stream trailer
+	data := make([]byte, 1<<22-1<<12)
+
+	f, err := os.Open("/dev/urandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.Read(data)
+	if err != nil || n != len(data) {
+		t.Fatal(err)
+	}
+
+	origSha256Sum := sha256.Sum256(data)
+
+	buf := bytes.NewBuffer(data)
+
+	p := make([]byte, 1024)
+
+	failures := expectFailures(t, 2, 5, 0)
+
+	for {
+		_, err := buf.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := ec.Write(p)
+		failures.check(n, len(p), err)
+	}
+
+	failures.done()
+
+	if ec.Health() != streammux.DEGRADED {
+		t.Fatal("expected three failed members to degrade a 4+3 array")
+	}
+
+	// close to reset position
+	if err := ec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen
+	ec.Open()
+
+	buf.Reset()
+
+	for {
+		_, err := ec.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = buf.Write(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newSha256Sum := sha256.Sum256(buf.Bytes())
+
+	if origSha256Sum != newSha256Sum {
+		t.Fatal("origSha256Sum != newSha256Sum")
+	}
+}
+
+// newErasureCoded returns an ErasureCoded array of the data and coding
+// members, failing the test if the geometry is invalid.
+func newErasureCoded(t testing.TB, data, coding []io.ReadWriteCloser, opts ...streammux.MemberOption) *streammux.ErasureCoded {
+	ec, err := streammux.NewErasureCoded(data, coding, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ec
+}
+
+func TestErasureCodedGeometry(t *testing.T) {
+	devices := func(n int) []io.ReadWriteCloser {
+		rwcs := make([]io.ReadWriteCloser, n)
+		for i := range rwcs {
+			rwcs[i] = testutil.NewBlockDevice(1 << 12)
+		}
+
+		return rwcs
+	}
+
+	if _, err := streammux.NewErasureCoded(nil, devices(2)); err == nil {
+		t.Fatal("expected an error without data members")
+	}
+
+	// the members are labelled with the 256 elements of GF(2^8)
+	if _, err := streammux.NewErasureCoded(devices(200), devices(57)); err == nil {
+		t.Fatal("expected an error for more than 256 members")
+	}
+
+	if _, err := streammux.NewErasureCoded(devices(200), devices(56)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,132 @@
+package streammux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrArrayFailed is returned when an array has lost more members than
+	// its redundancy allows for.
+	ErrArrayFailed = fmt.Errorf("array failed: %w", syscall.EIO)
+
+	// ErrMemberFailed is returned for I/O issued to a member that has
+	// already failed.
+	ErrMemberFailed = fmt.Errorf("member failed: %w", syscall.EIO)
+
+	// ErrNoRedundancy is returned by operations that need redundancy the
+	// array no longer has, such as rebuilds and scrubs.
+	ErrNoRedundancy = fmt.Errorf("no redundancy left: %w", syscall.EIO)
+
+	// ErrNoSpares is returned when a spare pool has run out of spares.
+	ErrNoSpares = errors.New("no spares")
+
+	// ErrTimeout is returned when a member does not complete a read or
+	// write within the time given with WithTimeout.
+	ErrTimeout = fmt.Errorf("member operation timed out: %w", context.DeadlineExceeded)
+
+	// ErrChecksum is returned when a chunk read from a member fails checksum
+	// verification.
+	ErrChecksum = errors.New("chunk checksum mismatch")
+)
+
+// MemberError records a failed operation on a member.
+type MemberError struct {
+	// Member is the index of the member in its array.
+	Member int
+
+	Op Op
+
+	// Offset is the position in the data stream of the member at which the
+	// operation started.
+	Offset int64
+
+	Err error
+}
+
+func (e *MemberError) Error() string {
+	return fmt.Sprintf("member %d: %s at offset %d: %v", e.Member, e.Op, e.Offset, e.Err)
+}
+
+func (e *MemberError) Unwrap() error {
+	return e.Err
+}
+
+// MemberErrors is returned when an operation failed on several members. It
+// lists every member failure.
+//
+// A write that the array survives returns the number of bytes written along
+// with the member failures, such that the caller learns of them while the
+// data is safe. ReadFrom, and io.Copy into a behavior with it, keeps copying
+// past such failures and returns them once the source is exhausted.
+type MemberErrors []*MemberError
+
+func (errs MemberErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d members failed: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the member errors such that errors.Is and errors.As match any
+// of them.
+func (errs MemberErrors) Unwrap() []error {
+	unwrapped := make([]error, len(errs))
+	for i, err := range errs {
+		unwrapped[i] = err
+	}
+
+	return unwrapped
+}
+
+// isMemberError reports whether err is or lists member failures.
+func isMemberError(err error) bool {
+	var merr *MemberError
+	return errors.As(err, &merr)
+}
+
+// add records err if it is a member failure, or the member failures listed by
+// err. I/O issued to a member that has already failed is not another failure
+// and is not recorded.
+func (errs *MemberErrors) add(err error) {
+	var merrs MemberErrors
+	if errors.As(err, &merrs) {
+		*errs = append(*errs, merrs...)
+		return
+	}
+
+	var merr *MemberError
+	if errors.As(err, &merr) && !errors.Is(merr, ErrMemberFailed) {
+		*errs = append(*errs, merr)
+	}
+}
+
+// join returns the error to report for a write that ended with err: every
+// member failure if there were several, and err otherwise.
+func (errs MemberErrors) join(err error) error {
+	if err == nil || err == io.EOF || len(errs) < 2 {
+		return err
+	}
+
+	return errs
+}
+
+// survived returns the error to report for a write that the array survived:
+// nil if no member failed, the failure if a single member failed, and every
+// member failure otherwise.
+func (errs MemberErrors) survived() error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	return errs
+}
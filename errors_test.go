@@ -0,0 +1,235 @@
+package streammux_test
+
+import (
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+func TestMemberErrors(t *testing.T) {
+	// opening the blank members reads and writes their superblocks, such
+	// that the third write fails
+	m := streammux.NewMirror(
+		testutil.NewFaultyDevice(1<<20, 4),
+		testutil.NewFaultyDevice(1<<20, 4),
+	)
+
+	m.Open()
+	defer m.Close()
+
+	p := make([]byte, 1024)
+
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		_, err = m.Write(p)
+	}
+
+	var errs streammux.MemberErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected the failures of both members, got %v", err)
+	}
+
+	for i, merr := range errs {
+		if merr.Op != streammux.OpWrite || merr.Offset != 2048 {
+			t.Fatalf("expected a write at offset 2048, got %v", merr)
+		}
+
+		if i > 0 && merr.Member == errs[0].Member {
+			t.Fatal("expected the failures of different members")
+		}
+	}
+
+	var merr *streammux.MemberError
+	if !errors.As(err, &merr) {
+		t.Fatal("expected errors.As to find a member error")
+	}
+
+	if !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected the device error to be wrapped, got %v", err)
+	}
+
+	// the array has failed now
+	if _, err := m.Write(p); !errors.Is(err, streammux.ErrArrayFailed) {
+		t.Fatalf("expected ErrArrayFailed, got %v", err)
+	}
+}
+
+func TestStripeMemberError(t *testing.T) {
+	s := streammux.NewStripe([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 1),
+	}, streammux.WithStripeUnit(512))
+
+	s.Open()
+	defer s.Close()
+
+	_, err := s.Write(make([]byte, 4096))
+
+	var merr *streammux.MemberError
+	if !errors.As(err, &merr) || merr.Member != 1 || merr.Op != streammux.OpWrite {
+		t.Fatalf("expected a write error on member 1, got %v", err)
+	}
+
+	if !errors.Is(merr, syscall.EIO) {
+		t.Fatalf("expected the device error, got %v", merr.Err)
+	}
+
+	if _, err := s.Write(make([]byte, 4096)); !errors.Is(err, streammux.ErrArrayFailed) {
+		t.Fatalf("expected ErrArrayFailed, got %v", err)
+	}
+}
+
+func TestNoSpares(t *testing.T) {
+	sp := streammux.NewSparePool(nil)
+
+	if _, err := sp.Get(); !errors.Is(err, streammux.ErrNoSpares) {
+		t.Fatalf("expected ErrNoSpares, got %v", err)
+	}
+
+	// a member that finds the pool empty fails with both errors
+	s := streammux.NewStripe([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 1),
+	}, streammux.WithStripeUnit(512), streammux.WithSparePool(sp))
+
+	s.Open()
+	defer s.Close()
+
+	_, err := s.Write(make([]byte, 4096))
+	if !errors.Is(err, streammux.ErrNoSpares) || !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected the device error and ErrNoSpares, got %v", err)
+	}
+
+	var merr *streammux.MemberError
+	if !errors.As(err, &merr) || merr.Member != 1 {
+		t.Fatalf("expected a write error on member 1, got %v", err)
+	}
+}
+
+func TestDegradedWriteMemberErrors(t *testing.T) {
+	arrays := map[string]func([]io.ReadWriteCloser) streammux.Array{
+		"Mirror": func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return streammux.NewMirror(rwcs[0], rwcs[1])
+		},
+		"DedicatedParity": func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return streammux.NewDedicatedParity(rwcs[0], rwcs[1:], streammux.WithStripeUnit(512))
+		},
+		"DistributedParity": func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return streammux.NewDistributedParity(rwcs, streammux.WithStripeUnit(512))
+		},
+		"DualParity": func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return newDualParity(t, rwcs[2], rwcs[3], rwcs[:2], streammux.WithStripeUnit(512))
+		},
+		"ErasureCoded": func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return newErasureCoded(t, rwcs[:3], rwcs[3:], streammux.WithStripeUnit(512))
+		},
+	}
+
+	for name, newArray := range arrays {
+		t.Run(name, func(t *testing.T) {
+			// member 1 fails on its first write after the superblock
+			a := newArray([]io.ReadWriteCloser{
+				testutil.NewBlockDevice(1 << 20),
+				testutil.NewFaultyDevice(1<<20, 1),
+				testutil.NewBlockDevice(1 << 20),
+				testutil.NewBlockDevice(1 << 20),
+			})
+
+			a.Open()
+			defer a.Close()
+
+			p := make([]byte, 6144)
+
+			// the array survives the failure, which is reported along with
+			// the data written
+			n, err := a.Write(p)
+			if n != len(p) {
+				t.Fatalf("expected %d bytes written, got %d: %v", len(p), n, err)
+			}
+
+			var merr *streammux.MemberError
+			if !errors.As(err, &merr) || merr.Op != streammux.OpWrite || errors.Is(err, streammux.ErrArrayFailed) {
+				t.Fatalf("expected the write error of a member, got %v", err)
+			}
+
+			// the failure is reported once
+			if _, err := a.Write(p); err != nil {
+				t.Fatalf("expected no more errors, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDualParityMemberErrors(t *testing.T) {
+	dp := newDualParity(t, testutil.NewBlockDevice(1<<20), testutil.NewBlockDevice(1<<20), []io.ReadWriteCloser{
+		testutil.NewFaultyDevice(1<<20, 1),
+		testutil.NewFaultyDevice(1<<20, 1),
+		testutil.NewBlockDevice(1 << 20),
+	}, streammux.WithStripeUnit(512))
+
+	dp.Open()
+	defer dp.Close()
+
+	p := make([]byte, 1536)
+
+	// both failures of a stripe the array survives are reported
+	n, err := dp.Write(p)
+	if n != len(p) {
+		t.Fatalf("expected %d bytes written, got %d: %v", len(p), n, err)
+	}
+
+	var errs streammux.MemberErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expected the failures of both members, got %v", err)
+	}
+
+	if dp.Health() != streammux.DEGRADED {
+		t.Fatal("expected the array to be DEGRADED")
+	}
+}
+
+// memberFailures checks the writes to an array that survives the failure of
+// members, which fail in the order given. Every write must be written in
+// full, and the only errors reported are the failures of the members, each
+// reported once.
+type memberFailures struct {
+	t       *testing.T
+	members []int
+}
+
+func expectFailures(t *testing.T, members ...int) *memberFailures {
+	return &memberFailures{t: t, members: members}
+}
+
+// check checks the result of a write of size bytes.
+func (f *memberFailures) check(n, size int, err error) {
+	f.t.Helper()
+
+	if n != size {
+		f.t.Fatalf("expected %d bytes written, got %d: %v", size, n, err)
+	}
+
+	if err == nil {
+		return
+	}
+
+	var merr *streammux.MemberError
+	if len(f.members) == 0 || !errors.As(err, &merr) || merr.Member != f.members[0] {
+		f.t.Fatalf("expected the failure of member %v, got %v", f.members, err)
+	}
+
+	f.members = f.members[1:]
+}
+
+// done checks that every member failure has been reported.
+func (f *memberFailures) done() {
+	f.t.Helper()
+
+	if len(f.members) > 0 {
+		f.t.Fatalf("expected the failure of member %v to be reported", f.members)
+	}
+}
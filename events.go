@@ -0,0 +1,215 @@
+package streammux
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies what an Event reports.
+type EventType int
+
+const (
+	// MemberFailed is sent when a member becomes FAILED.
+	MemberFailed EventType = iota
+
+	// ArrayDegraded is sent when an array becomes DEGRADED.
+	ArrayDegraded
+
+	// ArrayFailed is sent when an array becomes FAILED.
+	ArrayFailed
+
+	// SpareAllocated is sent when a member continues on a device taken from
+	// its spare pool.
+	SpareAllocated
+
+	// RebuildStarted is sent when a member starts being rebuilt.
+	RebuildStarted
+
+	// RebuildFinished is sent when the rebuild of a member has ended. The
+	// error is set if the rebuild did not complete.
+	RebuildFinished
+
+	// CorruptionDetected is sent when a chunk read from a member fails
+	// checksum verification.
+	CorruptionDetected
+)
+
+func (t EventType) String() string {
+	switch t {
+	case MemberFailed:
+		return "member failed"
+	case ArrayDegraded:
+		return "array degraded"
+	case ArrayFailed:
+		return "array failed"
+	case SpareAllocated:
+		return "spare allocated"
+	case RebuildStarted:
+		return "rebuild started"
+	case RebuildFinished:
+		return "rebuild finished"
+	case CorruptionDetected:
+		return "corruption detected"
+	}
+
+	return "unknown event"
+}
+
+// Event reports a change in the health of an array or one of its members.
+type Event struct {
+	Type EventType
+
+	// Behavior is the array the event occurred in.
+	Behavior Behavior
+
+	// Member is the index of the member concerned, or -1 for events
+	// concerning the array as a whole.
+	Member int
+
+	Time time.Time
+
+	// Err is the error that caused the event, if any.
+	Err error
+}
+
+// EventBus delivers events to its subscribers. Arrays publish to the bus
+// given with WithEvents, and several arrays may share a bus.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// WithEvents publishes the events of the array to bus.
+func WithEvents(bus *EventBus) MemberOption {
+	return func(o *memberOptions) {
+		o.events = bus
+	}
+}
+
+// Subscription receives the events published to an EventBus.
+type Subscription struct {
+	// C delivers the events. It is closed when the subscription is closed.
+	C <-chan Event
+
+	ch      chan Event
+	bus     *EventBus
+	dropped uint64
+}
+
+// Subscribe returns a subscription buffering up to size events. Events are
+// never waited for, so events that do not fit in the buffer are dropped.
+func (b *EventBus) Subscribe(size int) *Subscription {
+	ch := make(chan Event, size)
+
+	sub := &Subscription{
+		C:   ch,
+		ch:  ch,
+		bus: b,
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// Close stops delivery of events to the subscription.
+func (sub *Subscription) Close() {
+	sub.bus.mu.Lock()
+	defer sub.bus.mu.Unlock()
+
+	if _, ok := sub.bus.subs[sub]; ok {
+		delete(sub.bus.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer of the
+// subscription was full.
+func (sub *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&sub.dropped)
+}
+
+// publish delivers ev to every subscriber. Publishing to a nil bus does
+// nothing.
+func (b *EventBus) publish(ev Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+// emit publishes an event concerning the member.
+func (m *Member) emit(typ EventType, err error) {
+	if m.opts.events == nil || m.array == nil {
+		return
+	}
+
+	m.opts.events.publish(Event{
+		Type:     typ,
+		Behavior: m.array.behavior,
+		Member:   m.index,
+		Time:     time.Now(),
+		Err:      err,
+	})
+}
+
+// fail marks the member as FAILED because of err.
+func (m *Member) fail(err error) {
+	if State(atomic.SwapInt64(&m.state, int64(FAILED))) == FAILED {
+		return
+	}
+
+	m.emit(MemberFailed, err)
+}
+
+// observe publishes an event if the health of the array has worsened since it
+// was last observed.
+func (a *array) observe() {
+	health := a.behavior.Health()
+
+	a.Lock()
+	last := a.health
+	a.health = health
+	a.Unlock()
+
+	if health == last || a.events == nil {
+		return
+	}
+
+	var typ EventType
+
+	switch health {
+	case DEGRADED:
+		typ = ArrayDegraded
+	case FAILED:
+		typ = ArrayFailed
+	default:
+		return
+	}
+
+	a.events.publish(Event{
+		Type:     typ,
+		Behavior: a.behavior,
+		Member:   -1,
+		Time:     time.Now(),
+	})
+}
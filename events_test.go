@@ -0,0 +1,170 @@
+package streammux_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+// nextEvent returns the next event delivered to sub.
+func nextEvent(t *testing.T, sub *streammux.Subscription) streammux.Event {
+	select {
+	case ev := <-sub.C:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+
+	return streammux.Event{}
+}
+
+// expectEvent fails the test unless ev is of type typ and concerns member idx
+// of b.
+func expectEvent(t *testing.T, ev streammux.Event, typ streammux.EventType, b streammux.Behavior, idx int) {
+	if ev.Type != typ || ev.Behavior != b || ev.Member != idx {
+		t.Fatalf("expected %q on member %d, got %q on member %d", typ, idx, ev.Type, ev.Member)
+	}
+
+	if ev.Time.IsZero() {
+		t.Fatal("expected the event to be timestamped")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	bus := streammux.NewEventBus()
+
+	sub := bus.Subscribe(16)
+	defer sub.Close()
+
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 5),
+	}, streammux.WithEvents(bus))
+
+	data := make([]byte, 1<<14)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+
+	// the failure of the member is reported along with the data written
+	failures := expectFailures(t, 1)
+
+	for p := data; len(p) > 0; p = p[1024:] {
+		n, err := m.Write(p[:1024])
+		failures.check(n, 1024, err)
+	}
+
+	failures.done()
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := nextEvent(t, sub)
+	expectEvent(t, ev, streammux.MemberFailed, m, 1)
+
+	if !errors.Is(ev.Err, syscall.EIO) {
+		t.Fatalf("expected the event to carry the device error, got %v", ev.Err)
+	}
+
+	expectEvent(t, nextEvent(t, sub), streammux.ArrayDegraded, m, -1)
+
+	job := m.ReplaceContext(context.Background(), 1, testutil.NewBlockDevice(1<<20))
+
+	expectEvent(t, nextEvent(t, sub), streammux.RebuildStarted, m, 1)
+
+	ev = nextEvent(t, sub)
+	expectEvent(t, ev, streammux.RebuildFinished, m, 1)
+
+	if ev.Err != nil {
+		t.Fatal(ev.Err)
+	}
+
+	<-job.Done()
+
+	if n := sub.Dropped(); n != 0 {
+		t.Fatalf("expected no events to be dropped, got %d", n)
+	}
+}
+
+func TestSpareEvents(t *testing.T) {
+	bus := streammux.NewEventBus()
+
+	sub := bus.Subscribe(16)
+	defer sub.Close()
+
+	sparePool := streammux.NewSparePool([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+	})
+
+	s := streammux.NewStripe([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 1),
+	}, streammux.WithSparePool(sparePool), streammux.WithEvents(bus))
+
+	data := make([]byte, 1<<16)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Open()
+	writeArray(t, s, data)
+
+	expectEvent(t, nextEvent(t, sub), streammux.SpareAllocated, s, 1)
+
+	select {
+	case ev := <-sub.C:
+		t.Fatalf("expected the stripe to remain OK, got %q", ev.Type)
+	default:
+	}
+}
+
+func TestCorruptionEvents(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	bus := streammux.NewEventBus()
+
+	sub := bus.Subscribe(16)
+	defer sub.Close()
+
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{blkdevs[0], blkdevs[1]},
+		streammux.WithChecksums(),
+		streammux.WithEvents(bus),
+	)
+
+	data := make([]byte, 4096)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+	writeArray(t, m, data)
+
+	corrupt(t, blkdevs[1], streammux.SuperblockSize+100)
+
+	m.Open()
+	defer m.Close()
+
+	if _, err := io.ReadAll(m); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := nextEvent(t, sub)
+	expectEvent(t, ev, streammux.CorruptionDetected, m, 1)
+
+	if ev.Err == nil {
+		t.Fatal("expected the event to carry the checksum error")
+	}
+}
@@ -0,0 +1,88 @@
+package streammux
+
+// Arithmetic over GF(2^8) with the reducing polynomial x^8+x^4+x^3+x^2+1
+// (0x11d) and generator 2, as used by RAID-6 and Reed-Solomon codes.
+
+const gfPoly = 0x11d
+
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+
+	// gfMulTable[c] holds the products c*x for all x.
+	gfMulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPoly
+		}
+	}
+
+	// duplicate the table to avoid a modulo in gfMul
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+
+	for c := 0; c < 256; c++ {
+		for x := 0; x < 256; x++ {
+			gfMulTable[c][x] = gfMul(byte(c), byte(x))
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if b == 0 {
+		panic("division by zero in GF(2^8)")
+	}
+
+	if a == 0 {
+		return 0
+	}
+
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfDiv(1, a)
+}
+
+// gfPow returns the generator raised to the power e.
+func gfPow(e int) byte {
+	e %= 255
+	if e < 0 {
+		e += 255
+	}
+
+	return gfExp[e]
+}
+
+// gfMulSlice sets dst to c*src.
+func gfMulSlice(dst, src []byte, c byte) {
+	tbl := &gfMulTable[c]
+	for i, x := range src {
+		dst[i] = tbl[x]
+	}
+}
+
+// gfMulAddSlice adds (XORs) c*src into dst.
+func gfMulAddSlice(dst, src []byte, c byte) {
+	tbl := &gfMulTable[c]
+	for i, x := range src {
+		dst[i] ^= tbl[x]
+	}
+}
@@ -0,0 +1,181 @@
+package streammux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// checkpointSize is the size of a checkpoint in the rebuild journal.
+const checkpointSize = 48
+
+// checkpointInterval is the minimum time between checkpoints of a rebuild.
+const checkpointInterval = time.Second
+
+var checkpointMagic = [8]byte{'S', 'M', 'U', 'X', 'C', 'P', '0', '1'}
+
+var errBadCheckpoint = errors.New("invalid rebuild checkpoint")
+
+// WithRebuildJournal records the progress of rebuilds in a sidecar file at
+// path. A rebuild interrupted by the process exiting continues from the last
+// checkpoint when the array is next opened. The array must be assembled from
+// its devices for the checkpoint to be recognized.
+func WithRebuildJournal(path string) MemberOption {
+	return func(o *memberOptions) {
+		o.journal = path
+	}
+}
+
+// checkpoint records the offset up to which a member being rebuilt is known
+// to be consistent with the rest of the array.
+type checkpoint struct {
+	uuid       UUID
+	index      int
+	generation uint64
+	offset     int64
+}
+
+func (cp *checkpoint) marshal() []byte {
+	buf := make([]byte, checkpointSize)
+
+	copy(buf[0:8], checkpointMagic[:])
+	copy(buf[8:24], cp.uuid[:])
+	binary.LittleEndian.PutUint16(buf[24:], uint16(cp.index))
+	binary.LittleEndian.PutUint64(buf[28:], cp.generation)
+	binary.LittleEndian.PutUint64(buf[36:], uint64(cp.offset))
+
+	binary.LittleEndian.PutUint32(buf[44:], crc32.Checksum(buf[:44], castagnoli))
+
+	return buf
+}
+
+func (cp *checkpoint) unmarshal(buf []byte) error {
+	if len(buf) < checkpointSize || !bytes.Equal(buf[0:8], checkpointMagic[:]) {
+		return errBadCheckpoint
+	}
+
+	if binary.LittleEndian.Uint32(buf[44:]) != crc32.Checksum(buf[:44], castagnoli) {
+		return errBadCheckpoint
+	}
+
+	copy(cp.uuid[:], buf[8:24])
+	cp.index = int(binary.LittleEndian.Uint16(buf[24:]))
+	cp.generation = binary.LittleEndian.Uint64(buf[28:])
+	cp.offset = int64(binary.LittleEndian.Uint64(buf[36:]))
+
+	return nil
+}
+
+// writeCheckpoint replaces the journal at path with cp.
+func writeCheckpoint(path string, cp *checkpoint) error {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(cp.marshal()); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// readCheckpoint returns the checkpoint in the journal at path or nil if
+// there is none.
+func readCheckpoint(path string) (*checkpoint, error) {
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &checkpoint{}
+	if err := cp.unmarshal(buf); err != nil {
+		return nil, err
+	}
+
+	return cp, nil
+}
+
+func removeCheckpoint(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// skip moves the member past the superblock of its current segment and the
+// following n bytes without returning them.
+func (m *Member) skip(n int64) error {
+	if err := m.verifySuperblock(); err != nil {
+		return err
+	}
+
+	if seeker, ok := m.rwc.(io.Seeker); ok && m.upto == -1 {
+		if _, err := seeker.Seek(n, io.SeekCurrent); err != nil {
+			return err
+		}
+
+		m.pos += int(n)
+
+		return nil
+	}
+
+	buf := make([]byte, 32<<10)
+
+	for n > 0 {
+		q := buf
+		if int64(len(q)) > n {
+			q = q[:n]
+		}
+
+		k, err := m.readRaw(q)
+		n -= int64(k)
+
+		if err == io.EOF && n > 0 {
+			return io.ErrUnexpectedEOF
+		}
+
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// syncer is implemented by devices that can flush written data to stable
+// storage.
+type syncer interface {
+	Sync() error
+}
+
+// sync flushes the current segment of the member to stable storage if the
+// device supports it.
+func (m *Member) sync() error {
+	if s, ok := m.rwc.(syncer); ok {
+		return s.Sync()
+	}
+
+	return nil
+}
@@ -1,12 +1,42 @@
 package streammux
 
 import (
+	"context"
+	"fmt"
 	"io"
-	"syscall"
+	"sync/atomic"
+	"time"
 )
 
 type memberOptions struct {
 	spares *SparePool
+
+	// stripe unit used by the behavior owning the member
+	stripeUnit int
+
+	// whether data is framed in checksummed records
+	checksums bool
+
+	// bandwidth limit for rebuilds in bytes per second and the file holding
+	// rebuild checkpoints
+	rebuildRate int64
+	journal     string
+
+	// where state changes are published and member I/O is measured
+	events  *EventBus
+	metrics Metrics
+
+	// time allowed for a single read or write of a device
+	timeout time.Duration
+
+	// number of writes a behavior may queue ahead of its slowest member and
+	// number of stripes it reads ahead of the caller
+	queueDepth int
+	readAhead  int
+
+	// whether the behavior fills the member and moves on to the next one
+	// when it is full, such that running out of space is not a failure
+	fills bool
 }
 
 type MemberOption func(*memberOptions)
@@ -17,17 +47,78 @@ func WithSparePool(sp *SparePool) MemberOption {
 	}
 }
 
+// WithStripeUnit sets the number of bytes written to each member per stripe
+// by striping behaviors.
+func WithStripeUnit(n int) MemberOption {
+	return func(o *memberOptions) {
+		o.stripeUnit = n
+	}
+}
+
+// WithChecksums stores a CRC32C checksum with every chunk written to a member
+// and verifies it when the chunk is read back. A chunk that fails
+// verification is counted as a corruption on the member and is read from
+// another copy or reconstructed by behaviors with redundancy.
+func WithChecksums() MemberOption {
+	return func(o *memberOptions) {
+		o.checksums = true
+	}
+}
+
+func newMemberOptions(opts []MemberOption) memberOptions {
+	o := memberOptions{
+		stripeUnit: DefaultStripeUnit,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
 type Member struct {
 	rwc            io.ReadWriteCloser
 	currentSegment int
 	pos            int
 	upto           int
 
-	state State
+	// the state is accessed atomically, as behaviors with writes queued on
+	// the worker read it while the worker may change it
+	state int64
 
 	opts memberOptions
 
 	segments []*segment
+
+	// the array the member belongs to and its position in it. Members without
+	// an array have no superblock.
+	array *array
+	index int
+
+	// whether the superblock of the current segment has been handled and
+	// whether one was written since the member was opened
+	sbDone    bool
+	sbWritten bool
+
+	// the superblock found on the first segment when the member was opened,
+	// until the first read verifies its generation or the first write
+	// replaces it
+	sbFound *superblock
+
+	// the verified record being read when checksums are enabled
+	rbuf []byte
+	roff int
+
+	// number of chunks that failed checksum verification
+	corruptions uint64
+
+	// the context of the operation in progress on the behavior, if any
+	ctx context.Context
+
+	// queue of the worker issuing the requests of the behavior, started on
+	// the first request and stopped when the member is closed
+	requests chan ioRequest
 }
 
 type segment struct {
@@ -43,6 +134,15 @@ type rwT struct {
 	err error
 }
 
+// ioRequest is a read or write queued on the worker of a member. The result is
+// sent on ch.
+type ioRequest struct {
+	op  Op
+	idx int
+	p   []byte
+	ch  chan rwT
+}
+
 func NewMember(rwc io.ReadWriteCloser, opts ...MemberOption) *Member {
 	m := &Member{
 		rwc:      rwc,
@@ -54,19 +154,23 @@ func NewMember(rwc io.ReadWriteCloser, opts ...MemberOption) *Member {
 		upto: -1,
 	})
 
-	for _, opt := range opts {
-		opt(&m.opts)
-	}
+	m.opts = newMemberOptions(opts)
 
 	return m
 }
 
 func (m *Member) State() State {
-	return m.state
+	return State(atomic.LoadInt64(&m.state))
 }
 
 func (m *Member) SetState(state State) {
-	m.state = state
+	atomic.StoreInt64(&m.state, int64(state))
+}
+
+// Corruptions returns the number of chunks read from the member that failed
+// checksum verification.
+func (m *Member) Corruptions() uint64 {
+	return atomic.LoadUint64(&m.corruptions)
 }
 
 func (m *Member) Open() State {
@@ -74,90 +178,391 @@ func (m *Member) Open() State {
 	m.pos = 0
 	m.upto = m.segments[0].upto
 	m.currentSegment = 0
+	m.sbDone = false
+	m.sbFound = nil
+	m.rbuf = m.rbuf[:0]
+	m.roff = 0
 
 	if opener, ok := m.rwc.(Opener); ok {
 		// call the underlying member and record the state
-		m.state = opener.Open()
+		m.SetState(memberState(opener.Open()))
 	}
 
-	return m.state
+	if m.State() != FAILED {
+		if err := m.openSuperblock(); err != nil {
+			// the superblock is rewritten in place if the member is rebuilt
+			m.seekStart()
+			m.fail(err)
+		}
+	}
+
+	return m.State()
+}
+
+// memberState maps the health of a nested behavior to the state of the
+// member holding it. A behavior that is not OK, but still functional, makes
+// the member DEGRADED.
+func memberState(health State) State {
+	switch health {
+	case OK, FAILED:
+		return health
+	}
+
+	return DEGRADED
+}
+
+// updateHealth folds the health of a nested behavior into the member state.
+// A member that has failed is not revived until it is reopened.
+func (m *Member) updateHealth() {
+	if m.State() == FAILED {
+		return
+	}
+
+	if behavior, ok := m.rwc.(Behavior); ok {
+		if state := memberState(behavior.Health()); state == FAILED {
+			m.fail(nil)
+		} else {
+			m.SetState(state)
+		}
+	}
+}
+
+// usable reports whether I/O requests can be issued to the member.
+func (m *Member) usable() bool {
+	state := m.State()
+
+	return state == OK || state == DEGRADED
+}
+
+// anyDegraded reports whether any of the members are DEGRADED.
+func anyDegraded(members []*Member) bool {
+	for _, m := range members {
+		if m.State() == DEGRADED {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (m *Member) Close() error {
+	m.stop()
+
+	if m.sbWritten {
+		m.sbWritten = false
+		m.array.endWrite()
+	}
+
 	return m.rwc.Close()
 }
 
+// release closes a member that is discarded while the behavior is closed. Its
+// worker is stopped and its device closed, unless the device is opened with
+// the member, such as a nested behavior, and was closed with it.
+func (m *Member) release() error {
+	m.stop()
+
+	if _, ok := m.rwc.(Opener); ok {
+		return nil
+	}
+
+	return m.rwc.Close()
+}
+
+// Read reads from the member, stepping through its segments.
+func (m *Member) Read(p []byte) (n int, err error) {
+	ch := make(chan rwT, 1)
+	m.read(m.index, p, ch)
+	rc := <-ch
+
+	return rc.n, rc.err
+}
+
+// Write writes to the member, moving on to a spare if the device fails.
+func (m *Member) Write(p []byte) (n int, err error) {
+	ch := make(chan rwT, 1)
+	m.write(m.index, p, ch)
+	rc := <-ch
+
+	return rc.n, rc.err
+}
+
+// submit queues a read or write of p on the worker of the member, starting
+// the worker if it is not running. The result is sent on ch tagged with idx.
+func (m *Member) submit(op Op, idx int, p []byte, ch chan rwT) {
+	if m.requests == nil {
+		depth := m.opts.queueDepth + m.opts.readAhead
+		if depth < 1 {
+			depth = 1
+		}
+
+		m.requests = make(chan ioRequest, depth)
+		go m.serve(m.requests)
+	}
+
+	m.requests <- ioRequest{op, idx, p, ch}
+}
+
+// serve issues the requests queued on the member one at a time until the
+// queue is closed.
+func (m *Member) serve(requests chan ioRequest) {
+	for req := range requests {
+		if req.op == OpWrite {
+			m.write(req.idx, req.p, req.ch)
+		} else {
+			m.read(req.idx, req.p, req.ch)
+		}
+	}
+}
+
+// stop ends the worker of the member. Behaviors wait for the results of all
+// requests they submit, so none are pending.
+func (m *Member) stop() {
+	if m.requests != nil {
+		close(m.requests)
+		m.requests = nil
+	}
+}
+
 func (m *Member) write(idx int, p []byte, ch chan rwT) {
-	if m.state == FAILED {
-		ch <- rwT{idx, p, 0, syscall.EIO}
+	if m.State() == FAILED {
+		ch <- rwT{idx, p, 0, m.wrap(OpWrite, m.pos, ErrMemberFailed)}
 		return
 	}
 
+	var n int
+	var err error
+
+	off := m.pos
+	start := time.Now()
+
+	if m.opts.checksums {
+		n, err = m.writeRecords(p)
+	} else {
+		n, err = m.writeRaw(p)
+	}
+
+	// a member filled by its behavior has reached its end rather than failed
+	if m.opts.fills && full(err) {
+		err = io.EOF
+	}
+
+	m.observe(OpWrite, n, err, start)
+
+	m.updateHealth()
+
+	ch <- rwT{idx, p, n, m.wrap(OpWrite, off, err)}
+}
+
+// wrap returns err as a MemberError for an operation that started at off. The
+// end of the stream is not an error and is returned as is.
+func (m *Member) wrap(op Op, off int, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	return &MemberError{
+		Member: m.index,
+		Op:     op,
+		Offset: int64(off),
+		Err:    err,
+	}
+}
+
+// writeRaw writes p to the current segment, moving on to a spare if the
+// device fails. A member that has moved on to spares before writes along its
+// segments, such that a stream rewritten in place ends up where it is read
+// from.
+func (m *Member) writeRaw(p []byte) (written int, err error) {
 	for {
-		n, err := m.rwc.Write(p)
+		// move on to the next segment at the end of the current one
+		if m.upto != -1 && m.pos >= m.upto && m.currentSegment < len(m.segments)-1 {
+			m.nextSegment()
+
+			// the segment is written from its start
+			if seeker, ok := m.rwc.(io.Seeker); ok {
+				if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+					return written, err
+				}
+			}
+		}
+
+		// don't write past the end of the segment
+		q := p
+		if m.upto != -1 && m.pos < m.upto && m.pos+len(q) > m.upto {
+			q = q[:m.upto-m.pos]
+		}
+
+		var n int
+
+		err = m.writeSuperblock()
+		if err == nil {
+			n, err = m.device().Write(q)
+		}
+
+		// a nested array that wrote all of q despite member failures has not
+		// failed, it reports itself DEGRADED instead
+		if err != nil && n == len(q) && isMemberError(err) {
+			err = nil
+		}
+
 		m.pos += n
+		written += n
+		p = p[n:]
+
+		// the behavior decides what to do with a member that is full
+		if m.opts.fills && full(err) {
+			return written, err
+		}
 
 		if err != nil && err != io.EOF {
-			spare, err := m.opts.spares.Get()
-			if err != nil {
-				m.state = FAILED
+			// a member that failed an abandoned write is not moved on to a
+			// spare, nor is a write abandoned by the caller
+			if m.State() == FAILED || m.ctx != nil && m.ctx.Err() != nil {
+				m.fail(err)
+				return written, err
+			}
+
+			// the member fails with both the device error and the reason no
+			// spare was taken
+			spare, serr := m.opts.spares.Get()
+			if serr != nil {
+				err = fmt.Errorf("%w: %w", err, serr)
+				m.fail(err)
 			} else {
+				m.emit(SpareAllocated, err)
+
+				// m.rwc holds up to m.pos and the segments after it are
+				// given up
 				m.segments[m.currentSegment].upto = m.pos
-				m.currentSegment++
 
-				// m.rwc holds up to m.pos
-				m.segments = append(m.segments, &segment{
+				for _, seg := range m.segments[m.currentSegment+1:] {
+					seg.rwc.Close()
+				}
+
+				m.segments = append(m.segments[:m.currentSegment+1], &segment{
 					rwc:  spare,
 					upto: -1,
 				})
 
+				m.currentSegment++
+
 				// close the old device
 				m.rwc.Close()
 
 				// update the current device
 				m.rwc = spare
+				m.upto = -1
+				m.sbDone = false
 
 				// rest of write on new spare
-				p = p[n:]
 				continue
 			}
 		}
 
-		ch <- rwT{idx, p, n, err}
+		// continue on the next segment if the write stopped at its end
+		if err == nil && n > 0 && len(p) > 0 {
+			continue
+		}
 
-		break
+		return written, err
 	}
 }
 
 func (m *Member) read(idx int, p []byte, ch chan rwT) {
-	if m.state == FAILED {
-		ch <- rwT{idx, p, 0, syscall.EIO}
+	if m.State() == FAILED {
+		ch <- rwT{idx, p, 0, m.wrap(OpRead, m.pos, ErrMemberFailed)}
 		return
 	}
 
-	if m.upto != -1 && m.pos+len(p) > m.upto {
-		m.currentSegment++
-		m.rwc = m.segments[m.currentSegment].rwc
-		if m.segments[m.currentSegment].upto != -1 {
-			m.upto = m.upto + m.segments[m.currentSegment].upto
-		} else {
-			m.upto = -1
+	var n int
+	var err error
+
+	off := m.pos
+	start := time.Now()
+
+	if m.opts.checksums {
+		n, err = m.readRecord(p)
+	} else {
+		n, err = m.readRaw(p)
+	}
+
+	m.observe(OpRead, n, err, start)
+
+	switch {
+	case err == ErrChecksum:
+		// the device is fine, only the chunk is bad
+		atomic.AddUint64(&m.corruptions, 1)
+		m.emit(CorruptionDetected, err)
+	case err != nil && err != io.EOF:
+		m.fail(err)
+	}
+
+	m.updateHealth()
+
+	ch <- rwT{idx, p, n, m.wrap(OpRead, off, err)}
+}
+
+// readRaw reads from the current segment into p, continuing on the next
+// segment at segment boundaries.
+func (m *Member) readRaw(p []byte) (n int, err error) {
+	for {
+		// move on to the next segment when the current one is exhausted
+		if m.upto != -1 && m.pos >= m.upto {
+			m.nextSegment()
 		}
 
-		// open the device if needed
-		if opener, ok := m.rwc.(Opener); ok {
-			opener.Open()
+		if err = m.verifySuperblock(); err != nil {
+			break
 		}
 
-	}
+		// don't read past the end of the segment
+		q := p[n:]
+		if m.upto != -1 && m.pos+len(q) > m.upto {
+			q = q[:m.upto-m.pos]
+		}
 
-	n, err := m.rwc.Read(p)
+		var k int
+		k, err = m.device().Read(q)
 
-	if err != nil && err != io.EOF {
-		m.state = FAILED
+		n += k
+		m.pos += k
+
+		// continue on the next segment if the read stopped at its end
+		if err == nil && n < len(p) && m.upto != -1 && m.pos == m.upto {
+			continue
+		}
+
+		// segments with an unknown end (such as assembled ones) are read
+		// until EOF
+		if err == io.EOF && m.upto == -1 && m.currentSegment < len(m.segments)-1 {
+			m.nextSegment()
+			err = nil
+
+			if n < len(p) {
+				continue
+			}
+		}
+
+		break
 	}
 
-	m.pos += n
-	ch <- rwT{idx, p, n, err}
+	return n, err
+}
 
+// nextSegment moves the member on to the next segment. The device of the
+// current segment is closed, such that it starts over when the member is
+// opened again.
+func (m *Member) nextSegment() {
+	m.rwc.Close()
+
+	m.currentSegment++
+	m.rwc = m.segments[m.currentSegment].rwc
+	m.upto = m.segments[m.currentSegment].upto
+	m.sbDone = false
+
+	// open the device if needed
+	if opener, ok := m.rwc.(Opener); ok {
+		opener.Open()
+	}
 }
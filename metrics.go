@@ -0,0 +1,82 @@
+package streammux
+
+import "time"
+
+// Op is the kind of I/O operation issued to a member.
+type Op int
+
+const (
+	OpRead Op = iota
+	OpWrite
+)
+
+func (op Op) String() string {
+	if op == OpWrite {
+		return "write"
+	}
+
+	return "read"
+}
+
+// MemberOp describes a read or write issued to a member by its behavior.
+type MemberOp struct {
+	// Array is the UUID of the array the member belongs to and Member is its
+	// index in the array.
+	Array  UUID
+	Member int
+
+	Op      Op
+	Bytes   int
+	Latency time.Duration
+
+	// Err is the error returned by the operation, which is io.EOF at the end
+	// of the stream.
+	Err error
+}
+
+// Metrics receives a measurement of every read and write issued to a member.
+// It is called from the goroutines doing the I/O and must not block.
+type Metrics interface {
+	ObserveMemberOp(op MemberOp)
+}
+
+// WithMetrics reports the I/O of the members to mt.
+func WithMetrics(mt Metrics) MemberOption {
+	return func(o *memberOptions) {
+		o.metrics = mt
+	}
+}
+
+// Index returns the position of the member in its array.
+func (m *Member) Index() int {
+	return m.index
+}
+
+// ArrayUUID returns the UUID of the array the member belongs to.
+func (m *Member) ArrayUUID() UUID {
+	if m.array == nil {
+		return UUID{}
+	}
+
+	// the identity is adopted when the array is opened
+	m.array.Lock()
+	defer m.array.Unlock()
+
+	return m.array.uuid
+}
+
+// observe reports an operation on the member that started at start.
+func (m *Member) observe(op Op, n int, err error, start time.Time) {
+	if m.opts.metrics == nil {
+		return
+	}
+
+	m.opts.metrics.ObserveMemberOp(MemberOp{
+		Array:   m.ArrayUUID(),
+		Member:  m.index,
+		Op:      op,
+		Bytes:   n,
+		Latency: time.Since(start),
+		Err:     err,
+	})
+}
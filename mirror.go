@@ -1,112 +1,218 @@
 package streammux
 
 import (
+	"context"
+	"errors"
 	"io"
-	"log"
+	"sort"
 	"sync"
-	"syscall"
 )
 
 type Mirror struct {
 	sync.Mutex
 	seq int
 
-	ios []*Member
+	ios  []*Member
+	opts memberOptions
 
-	replaced chan int
-	state    State
+	// the rebuild being run by Sync, if any
+	replaced chan *RebuildJob
+	job      *RebuildJob
+
+	// bytes written in the current session and the size of the last stream
+	// written as stored on the members
+	written int64
+	size    int64
+
+	// whether the rebuild journal has been checked for an interrupted rebuild
+	resumed bool
+
+	// the array identity shared by the members
+	array *array
+
+	// the reads and writes in flight on the members
+	ahead *readahead
+	pipe  *pipeline
+
+	state health
 }
 
+// NewMirror returns a Mirror of ios. Media holding a mirror of the same
+// geometry is adopted when opened and blank media is formatted.
 func NewMirror(ios ...io.ReadWriteCloser) *Mirror {
+	return NewMirrorWithOptions(ios)
+}
+
+// NewMirrorWithOptions returns a Mirror of ios with the given member options.
+func NewMirrorWithOptions(ios []io.ReadWriteCloser, opts ...MemberOption) *Mirror {
 	mirror := &Mirror{
 		ios:      make([]*Member, len(ios)),
-		replaced: make(chan int),
+		opts:     newMemberOptions(opts),
+		replaced: make(chan *RebuildJob, len(ios)),
 	}
 
 	for i, streamer := range ios {
-		mirror.ios[i] = NewMember(streamer)
+		mirror.ios[i] = NewMember(streamer, opts...)
 	}
 
+	mirror.array = attach(mirror, KindMirror, 1, 0, mirror.ios)
+	mirror.ahead = newReadahead(mirror.opts.readAhead, len(ios), mirror.issueRead, mirror.completeRead)
+	mirror.pipe = newPipeline(mirror.opts.queueDepth, len(ios), mirror.completeWrite)
+
 	go mirror.Sync()
 
 	return mirror
 }
 
 func (m *Mirror) Health() State {
-	return m.state
+	return m.state.get()
+}
+
+// Members returns the members of the behavior.
+func (m *Mirror) Members() []*Member {
+	return m.ios
 }
 
 func (m *Mirror) Open() State {
 	m.Lock()
 
+	// foreground I/O takes priority over a running rebuild
+	if m.job != nil {
+		m.job.suspend()
+	}
+
+	if !m.resumed {
+		m.resumed = true
+		m.resume()
+	}
+
 	// reset state
-	m.state = OK
+	m.state.set(OK)
+	m.written = 0
 
 	// we need at least one operational member to not be in state FAILED
 	var numFailed int
 
 	for _, rwc := range m.ios {
+		// members awaiting or undergoing a rebuild are left to Sync
+		if rebuilding(rwc) {
+			m.state.set(DEGRADED)
+
+			numFailed++
+			if numFailed == len(m.ios) {
+				m.state.set(FAILED)
+			}
+
+			continue
+		}
+
 		state := rwc.Open()
 
 		switch state {
 		case DEGRADED:
 			// if the member is degraded that is ok, the mirror is then also just degraded
-			m.state = DEGRADED
+			m.state.set(DEGRADED)
 		case FAILED:
 			// if a member is failed, we mark as degraded
-			m.state = DEGRADED
+			m.state.set(DEGRADED)
 
 			// then check if we have too many failures
 			numFailed++
 			if numFailed == len(m.ios) {
-				m.state = FAILED
+				m.state.set(FAILED)
 			}
 		}
 	}
 
-	return m.state
+	m.array.observe()
+
+	return m.state.get()
 }
 
 func (m *Mirror) Close() (err error) {
 	defer m.Unlock()
 
+	// wait for the queued writes and drop the reads issued ahead
+	flushErr := m.pipe.flush()
+	m.ahead.reset()
+
+	if m.written > 0 {
+		for _, member := range m.ios {
+			if member.usable() {
+				m.size = int64(member.pos)
+				break
+			}
+		}
+	}
+
 	for _, closer := range m.ios {
-		err = closer.Close()
+		if rebuilding(closer) {
+			continue
+		}
+
+		// the mirror does not depend on members that have failed
+		if cerr := closer.Close(); cerr != nil && closer.usable() {
+			err = cerr
+		}
+	}
+
+	if flushErr != nil {
+		err = flushErr
 	}
 
 	return
 }
 
 func (m *Mirror) Read(p []byte) (n int, err error) {
-	ch := make(chan rwT)
+	defer m.array.observe()
 
-	var active []struct{}
+	return m.ahead.stream(p, m.opts.stripeUnit)
+}
 
+// issueRead submits the reads of r.p to the usable members.
+func (m *Mirror) issueRead(r *inflight) error {
 	for i, reader := range m.ios {
-		if reader.State() != OK {
+		if !reader.usable() {
 			continue
 		}
 
-		// allocate a new slice for the read
-		go reader.read(i, make([]byte, len(p)), ch)
+		// every copy is read into a buffer of its own
+		buf := getBuffer(len(r.p))
+		r.hold(buf)
 
-		active = append(active, struct{}{})
+		r.fetch(reader, i, *buf)
 	}
 
-	if len(active) == 0 {
-		return 0, syscall.EIO
+	if r.active == 0 {
+		return ErrArrayFailed
 	}
 
+	return nil
+}
+
+// completeRead collects the results of a read issued by issueRead and copies
+// the first good copy to r.p.
+func (m *Mirror) completeRead(r *inflight) (n int, err error) {
 	var readSucceeded bool
 
-	for range active {
-		rc := <-ch
+	for i := 0; i < r.active; i++ {
+		rc := <-r.results
+
+		// a corrupt copy is skipped, but the member remains usable
+		if errors.Is(rc.err, ErrChecksum) {
+			if !readSucceeded {
+				n = rc.n
+				err = rc.err
+			}
+
+			continue
+		}
 
 		if rc.err != nil && rc.err != io.EOF {
-			if len(active) > 1 {
-				m.state = DEGRADED
+			if r.active > 1 {
+				m.state.set(DEGRADED)
 			} else {
-				m.state = FAILED
+				m.state.set(FAILED)
 			}
 
 			if !readSucceeded {
@@ -121,48 +227,60 @@ func (m *Mirror) Read(p []byte) (n int, err error) {
 			n = rc.n
 			err = rc.err
 
-			copy(p, rc.p[:n])
+			copy(r.p, rc.p[:n])
 		}
 
 		readSucceeded = true
 	}
 
+	if m.state.get() == OK && anyDegraded(m.ios) {
+		m.state.set(DEGRADED)
+	}
+
 	return
 }
 
 func (m *Mirror) Write(p []byte) (n int, err error) {
+	defer m.array.observe()
+
 	m.seq++
-	ch := make(chan rwT)
 
-	var active []struct{}
+	w, p := m.pipe.begin(p)
 
 	for i, writer := range m.ios {
-		if writer.State() != OK {
+		if !writer.usable() {
 			continue
 		}
 
-		go writer.write(i, p, ch)
-
-		active = append(active, struct{}{})
+		w.submit(writer, i, p)
 	}
 
-	if len(active) == 0 {
-		return 0, syscall.EIO
+	if w.active == 0 {
+		m.pipe.abort(w)
+		return 0, ErrArrayFailed
 	}
 
+	return m.pipe.end(w)
+}
+
+// completeWrite collects the results of a write issued by Write.
+func (m *Mirror) completeWrite(w *inflight) (n int, err error) {
 	var writeSucceeded bool
+	var failures MemberErrors
 
-	for range active {
-		rc := <-ch
+	for i := 0; i < w.active; i++ {
+		rc := <-w.results
 
 		//log.Printf("seq=%d, rc.n=%d, rc.err=%v", m.seq, rc.n, rc.err)
 
 		if rc.err != nil && rc.err != io.EOF {
-			if len(active) > 1 {
-				m.state = DEGRADED
+			failures.add(rc.err)
+
+			if w.active > 1 {
+				m.state.set(DEGRADED)
 				//log.Print("mirror DEGRADED")
 			} else {
-				m.state = FAILED
+				m.state.set(FAILED)
 			}
 
 			if !writeSucceeded {
@@ -181,58 +299,186 @@ func (m *Mirror) Write(p []byte) (n int, err error) {
 		writeSucceeded = true
 	}
 
-	return
+	if m.state.get() == OK && anyDegraded(m.ios) {
+		m.state.set(DEGRADED)
+	}
+
+	m.written += int64(n)
+
+	if writeSucceeded && err == nil {
+		return n, failures.survived()
+	}
+
+	return n, failures.join(err)
 }
 
 // Replace replaces the io.ReadWriteCloser at idx in mirror with rwc and
 // signals the Sync function to begin synchronizing the mirror.
 func (m *Mirror) Replace(idx int, rwc io.ReadWriteCloser) {
+	m.ReplaceContext(context.Background(), idx, rwc)
+}
+
+// ReplaceContext replaces the io.ReadWriteCloser at idx in mirror with rwc
+// and returns the job rebuilding it. The rebuild runs in the background and
+// is cancelled with ctx.
+//
+// The mirror remains usable while the rebuild runs. Opening the mirror
+// suspends the rebuild, which continues once the mirror is closed again. It
+// starts over if a new stream was written in the meantime.
+func (m *Mirror) ReplaceContext(ctx context.Context, idx int, rwc io.ReadWriteCloser) *RebuildJob {
+	m.Lock()
+
+	// the new member takes the place of the old one in the array
+	member := NewMember(rwc)
+	member.opts = m.ios[idx].opts
+	member.array, member.index = m.ios[idx].array, idx
+
+	// a running rebuild lets go of the members it holds open
+	if m.job != nil {
+		m.job.suspend()
+	}
+
+	old := m.ios[idx]
+	m.ios[idx] = member
+
+	// the old member is discarded, so a failure to close it is of no concern
+	old.release()
+
+	m.Unlock()
+
+	return m.resync(ctx, idx)
+}
+
+// resync returns the job rebuilding the member at idx onto its own devices,
+// keeping the spare segments it has moved on to.
+func (m *Mirror) resync(ctx context.Context, idx int) *RebuildJob {
+	m.Lock()
+	m.ios[idx].SetState(REPLACED)
+	m.Unlock()
+
+	job := newRebuildJob(ctx, idx, m.opts)
+
+	m.replaced <- job
+
+	return job
+}
+
+// Sync runs the rebuilds of replaced members, one at a time.
+func (m *Mirror) Sync() {
+	for job := range m.replaced {
+		m.rebuild(job)
+	}
+}
+
+// Scrub reads every member of the mirror end to end and compares the copies
+// chunk by chunk. A copy is held responsible for a mismatch if it fails
+// checksum verification or differs from the copy held by a majority of the
+// members. With two copies and no checksums the bad copy cannot be told
+// apart from the good one.
+//
+// If repair is set, the members held responsible are rebuilt from the others
+// onto their own devices, and Scrub returns once the rebuilds have finished.
+// Nothing is repaired if any mismatch could not be attributed to a member.
+func (m *Mirror) Scrub(ctx context.Context, repair bool) (*ScrubReport, error) {
 	m.Open()
+	report, err := m.scrub(ctx)
+	m.Close()
+
+	if err != nil || !repair {
+		return report, err
+	}
 
-	// close the existing member
-	if err := m.ios[idx].Close(); err != nil {
-		panic(err)
+	var jobs []*RebuildJob
+	for _, idx := range report.culprits() {
+		jobs = append(jobs, m.resync(ctx, idx))
+		report.Repaired = append(report.Repaired, idx)
 	}
 
-	m.ios[idx] = NewMember(rwc)
+	for _, job := range jobs {
+		<-job.Done()
 
-	m.ios[idx].Open()
-	/*
-		if opener, ok := m.ios[idx].rwc.(Opener); ok {
-			opener.Open()
+		if err := job.Err(); err != nil {
+			return report, err
 		}
-	*/
+	}
 
-	m.replaced <- idx
+	return report, nil
 }
 
-func (m *Mirror) Sync() {
+// scrub compares the copies held by the usable members. The mirror must be
+// open.
+func (m *Mirror) scrub(ctx context.Context) (*ScrubReport, error) {
+	report := &ScrubReport{}
+	ch := make(chan rwT)
+
 	for {
-		// wait for a replaced drive
-		idx := <-m.replaced
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
 
-		// find a reader that is OK
-		for _, reader := range m.ios {
-			if reader.State() == OK {
+		var active []struct{}
 
-				// rebuild the replaced writer by copying until EOF from the reader
-				m.ios[idx].SetState(REBUILDING)
+		for i, reader := range m.ios {
+			if !reader.usable() {
+				continue
+			}
 
-				written, err := io.Copy(m.ios[idx].rwc, reader.rwc)
-				if err != nil {
-					break
-				}
+			reader.submit(OpRead, i, make([]byte, scrubChunkSize), ch)
 
-				_ = written
+			active = append(active, struct{}{})
+		}
 
-				log.Printf("finished rebuilding, copied %d bytes", written)
+		// there is nothing to compare a single copy to
+		if len(active) < 2 {
+			return report, ErrNoRedundancy
+		}
 
-				m.ios[idx].SetState(OK)
+		chunks := make(map[int][]byte)
+		var bad []int
 
-				break
+		for range active {
+			rc := <-ch
+
+			switch {
+			case errors.Is(rc.err, ErrChecksum):
+				bad = append(bad, rc.idx)
+			case rc.err != nil && rc.err != io.EOF:
+				// the member has failed and is left out from now on
+				m.state.set(DEGRADED)
+			default:
+				chunks[rc.idx] = rc.p[:rc.n]
 			}
 		}
 
-		m.Close()
+		var size int
+		for _, chunk := range chunks {
+			if len(chunk) > size {
+				size = len(chunk)
+			}
+		}
+
+		if size == 0 && len(bad) == 0 {
+			return report, nil
+		}
+
+		if len(chunks) == 0 {
+			// every copy is corrupt, so there is nothing to repair from
+			report.Mismatches = append(report.Mismatches, Mismatch{report.Bytes, -1})
+			continue
+		}
+
+		differ, ok := vote(chunks)
+		if !ok {
+			report.Mismatches = append(report.Mismatches, Mismatch{report.Bytes, -1})
+		}
+
+		bad = append(bad, differ...)
+		sort.Ints(bad)
+
+		for _, idx := range bad {
+			report.Mismatches = append(report.Mismatches, Mismatch{report.Bytes, idx})
+		}
+
+		report.Bytes += int64(size)
 	}
 }
@@ -2,10 +2,14 @@ package streammux_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/bh107/streammux"
 	"github.com/bh107/streammux/pkg/util/testutil"
@@ -21,7 +25,8 @@ func TestMirror(t *testing.T) {
 
 	m.Open()
 
-	data := make([]byte, 1<<20)
+	// leave room for the superblock
+	data := make([]byte, 1<<20-1<<12)
 
 	f, err := os.Open("/dev/urandom")
 	if err != nil {
@@ -39,6 +44,8 @@ func TestMirror(t *testing.T) {
 
 	p := make([]byte, 1024)
 
+	failures := expectFailures(t, 1)
+
 	for {
 		_, err := buf.Read(p)
 		if err == io.EOF {
@@ -50,11 +57,11 @@ func TestMirror(t *testing.T) {
 		}
 
 		n, err := m.Write(p)
-		if err != nil || n != len(p) {
-			t.Fatal(err)
-		}
+		failures.check(n, len(p), err)
 	}
 
+	failures.done()
+
 	// close to reset position
 	if err := m.Close(); err != nil {
 		t.Fatal(err)
@@ -90,3 +97,246 @@ func TestMirror(t *testing.T) {
 		t.Fatal("origSha256Sum != newSha256Sum")
 	}
 }
+
+func TestMirrorRebuild(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{blkdevs[0], blkdevs[1]},
+		streammux.WithRebuildRate(1<<20),
+	)
+
+	data := make([]byte, 1<<18)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+	writeArray(t, m, data)
+
+	start := time.Now()
+
+	job := m.ReplaceContext(context.Background(), 1, testutil.NewBlockDevice(1<<20))
+
+	// the mirror remains usable while rebuilding
+	m.Open()
+
+	if m.Health() != streammux.DEGRADED {
+		t.Fatal("expected the mirror to be DEGRADED while rebuilding")
+	}
+
+	got, err := io.ReadAll(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read while rebuilding differs from the data written")
+	}
+
+	m.Close()
+
+	<-job.Done()
+
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if done, total := job.Progress(); done != int64(len(data)) || total != int64(len(data)) {
+		t.Fatalf("expected %d of %d bytes to be copied, got %d of %d", len(data), len(data), done, total)
+	}
+
+	// the rate limit allows a quarter of a second for the data
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("rebuild was not rate limited, took %v", elapsed)
+	}
+
+	// wipe the source such that the data must come from the rebuilt member
+	restore(t, blkdevs[0], make([]byte, 1<<20))
+
+	m.Open()
+	defer m.Close()
+
+	got, err = io.ReadAll(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read from the rebuilt member differs from the data written")
+	}
+}
+
+func TestMirrorRebuildCancel(t *testing.T) {
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}, streammux.WithRebuildRate(1<<16))
+
+	m.Open()
+	writeArray(t, m, make([]byte, 1<<18))
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := m.ReplaceContext(ctx, 1, testutil.NewBlockDevice(1<<20))
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	<-job.Done()
+
+	if job.Err() != context.Canceled {
+		t.Fatalf("expected the rebuild to be cancelled, got %v", job.Err())
+	}
+
+	if done, total := job.Progress(); done >= total {
+		t.Fatalf("expected the rebuild to be incomplete, copied %d of %d bytes", done, total)
+	}
+
+	if state := m.Members()[1].State(); state != streammux.REPLACED {
+		t.Fatalf("expected the member to await a rebuild, got %v", state)
+	}
+
+	if m.Open() != streammux.DEGRADED {
+		t.Fatal("expected the mirror to be DEGRADED")
+	}
+
+	m.Close()
+}
+
+// countingDevice counts the bytes written to a block device.
+type countingDevice struct {
+	*testutil.BlockDevice
+	written int
+}
+
+func (d *countingDevice) Write(p []byte) (int, error) {
+	n, err := d.BlockDevice.Write(p)
+	d.written += n
+
+	return n, err
+}
+
+func TestMirrorRebuildResume(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "rebuild.journal")
+
+	src := testutil.NewBlockDevice(1 << 20)
+	dst := testutil.NewBlockDevice(1 << 20)
+
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{src, testutil.NewBlockDevice(1 << 20)},
+		streammux.WithRebuildRate(1<<19),
+		streammux.WithRebuildJournal(journal),
+	)
+
+	data := make([]byte, 1<<19)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+	writeArray(t, m, data)
+
+	// interrupt the rebuild about halfway through
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := m.ReplaceContext(ctx, 1, dst)
+
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+
+	<-job.Done()
+
+	if _, err := os.Stat(journal); err != nil {
+		t.Fatal("expected a checkpoint to be kept for the interrupted rebuild")
+	}
+
+	// assemble the array again as if the process had been restarted
+	counting := &countingDevice{BlockDevice: dst}
+
+	arrays, err := streammux.Assemble([]io.ReadWriteCloser{counting, src},
+		streammux.WithRebuildJournal(journal),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the assembled mirror is already open
+	mirror := arrays[0].(*streammux.Mirror)
+
+	if mirror.Health() != streammux.DEGRADED {
+		t.Fatal("expected the mirror to be DEGRADED until the rebuild has finished")
+	}
+
+	mirror.Close()
+
+	job = mirror.Rebuild()
+	if job == nil {
+		t.Fatal("expected the interrupted rebuild to continue")
+	}
+
+	<-job.Done()
+
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if counting.written == 0 || counting.written >= len(data) {
+		t.Fatalf("expected the rebuild to continue from the checkpoint, wrote %d bytes", counting.written)
+	}
+
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Fatal("expected the checkpoint to be removed after the rebuild")
+	}
+
+	// wipe the source such that the data must come from the rebuilt member
+	restore(t, src, make([]byte, 1<<20))
+
+	mirror.Open()
+	defer mirror.Close()
+
+	got, err := io.ReadAll(mirror)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read from the rebuilt member differs from the data written")
+	}
+}
+
+// closeCountingDevice counts the times it is closed.
+type closeCountingDevice struct {
+	*testutil.BlockDevice
+
+	closes int
+}
+
+func (d *closeCountingDevice) Close() error {
+	d.closes++
+
+	return d.BlockDevice.Close()
+}
+
+func TestMirrorReplaceClosesMember(t *testing.T) {
+	old := &closeCountingDevice{BlockDevice: testutil.NewBlockDevice(1 << 20)}
+
+	m := streammux.NewMirror(testutil.NewBlockDevice(1<<20), old)
+
+	m.Open()
+	writeArray(t, m, make([]byte, 1<<16))
+
+	closes := old.closes
+
+	job := m.ReplaceContext(context.Background(), 1, testutil.NewBlockDevice(1<<20))
+	<-job.Done()
+
+	if err := job.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if old.closes != closes+1 {
+		t.Fatal("expected the replaced member to be closed")
+	}
+}
@@ -1,5 +1,10 @@
 package streammux
 
+import (
+	"io"
+	"sync/atomic"
+)
+
 type State int
 
 const (
@@ -19,6 +24,19 @@ const (
 	FAILED
 )
 
+// health holds the state of a behavior. It is changed with the behavior
+// locked, but read atomically, as Health may be called at any time, such as
+// by a metrics exporter.
+type health int64
+
+func (h *health) get() State {
+	return State(atomic.LoadInt64((*int64)(h)))
+}
+
+func (h *health) set(state State) {
+	atomic.StoreInt64((*int64)(h), int64(state))
+}
+
 // Opener is implemented by types that can be opened, returning a state.
 type Opener interface {
 	Open() State
@@ -28,3 +46,20 @@ type Opener interface {
 type Behavior interface {
 	Health() State
 }
+
+// Array is implemented by all behaviors and is what Assemble returns.
+//
+// Open reads the superblock of every member. A new array adopts the identity
+// of the array found on its members, which must have been created with the
+// same geometry, such that existing media can be reopened without Assemble.
+// A member holding the superblock of another array fails. Blank media is
+// given a superblock when opened.
+type Array interface {
+	Behavior
+	Opener
+	io.ReadWriteCloser
+
+	// Members returns the members of the array in the order of their
+	// superblock index.
+	Members() []*Member
+}
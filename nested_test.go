@@ -0,0 +1,199 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+func TestRAID10(t *testing.T) {
+	blkdevs := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	s := streammux.NewStripe([]io.ReadWriteCloser{
+		streammux.NewMirror(blkdevs[:2]...),
+		streammux.NewMirror(blkdevs[2:]...),
+	}, streammux.WithStripeUnit(512))
+
+	if s.Open() != streammux.OK {
+		t.Fatal("expected a fresh array to be OK")
+	}
+
+	// leave room for the superblock and stream trailer
+	data := make([]byte, 1<<21-1<<12)
+
+	f, err := os.Open("/dev/urandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.Read(data)
+	if err != nil || n != len(data) {
+		t.Fatal(err)
+	}
+
+	origSha256Sum := sha256.Sum256(data)
+
+	buf := bytes.NewBuffer(data)
+
+	p := make([]byte, 1024)
+
+	for {
+		_, err := buf.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := s.Write(p)
+		if err != nil || n != len(p) {
+			t.Fatal(err, n)
+		}
+	}
+
+	// the failed mirror member must surface as a degraded stripe
+	if s.Health() != streammux.DEGRADED {
+		t.Fatal("expected the stripe to be DEGRADED")
+	}
+
+	// close to reset position
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen
+	if s.Open() != streammux.DEGRADED {
+		t.Fatal("expected the stripe to reopen as DEGRADED")
+	}
+
+	buf.Reset()
+
+	for {
+		_, err := s.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = buf.Write(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newSha256Sum := sha256.Sum256(buf.Bytes())
+
+	if origSha256Sum != newSha256Sum {
+		t.Fatal("origSha256Sum != newSha256Sum")
+	}
+}
+
+func TestRAID50(t *testing.T) {
+	blkdevs := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 100),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	s := streammux.NewStripe([]io.ReadWriteCloser{
+		streammux.NewDedicatedParity(blkdevs[0], blkdevs[1:4], streammux.WithStripeUnit(256)),
+		streammux.NewDistributedParity(blkdevs[4:], streammux.WithStripeUnit(256)),
+	}, streammux.WithStripeUnit(768))
+
+	if s.Open() != streammux.OK {
+		t.Fatal("expected a fresh array to be OK")
+	}
+
+	data := make([]byte, 3<<19)
+
+	f, err := os.Open("/dev/urandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.Read(data)
+	if err != nil || n != len(data) {
+		t.Fatal(err)
+	}
+
+	origSha256Sum := sha256.Sum256(data)
+
+	buf := bytes.NewBuffer(data)
+
+	p := make([]byte, 1536)
+
+	for {
+		_, err := buf.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := s.Write(p)
+		if err != nil || n != len(p) {
+			t.Fatal(err, n)
+		}
+	}
+
+	// the failed parity member must surface as a degraded stripe
+	if s.Health() != streammux.DEGRADED {
+		t.Fatal("expected the stripe to be DEGRADED")
+	}
+
+	// close to reset position
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen
+	if s.Open() != streammux.DEGRADED {
+		t.Fatal("expected the stripe to reopen as DEGRADED")
+	}
+
+	buf.Reset()
+
+	for {
+		_, err := s.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = buf.Write(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newSha256Sum := sha256.Sum256(buf.Bytes())
+
+	if origSha256Sum != newSha256Sum {
+		t.Fatal("origSha256Sum != newSha256Sum")
+	}
+}
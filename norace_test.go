@@ -0,0 +1,5 @@
+//go:build !race
+
+package streammux_test
+
+const raceEnabled = false
@@ -0,0 +1,272 @@
+package streammux
+
+// WithQueueDepth makes writes asynchronous, with up to n writes queued on the
+// members of a behavior ahead of the slowest member. Write returns once its
+// data is queued, such that fast members, tape drives in particular, keep
+// streaming while a slow member catches up.
+//
+// A queued write that fails is reported by a later Write or by Flush, which
+// waits for the queued writes to complete. Closing the behavior flushes it.
+// ReadContext and WriteContext wait for the queued writes before and after
+// they run. With n = 0, the default, every Write waits for all members.
+func WithQueueDepth(n int) MemberOption {
+	return func(o *memberOptions) {
+		o.queueDepth = n
+	}
+}
+
+// inflight is a read or write issued to the members of a behavior whose
+// results have not been collected yet.
+type inflight struct {
+	results chan rwT
+
+	// number of members the request was issued to and the size of a write
+	active int
+	size   int
+
+	// the data of a read and a member index recorded by the behavior when the
+	// read is issued, such as the member left out
+	p   []byte
+	idx int
+
+	// the error of issuing a read, returned once the read completes
+	err error
+
+	// buffers released once every member has completed the request
+	bufs []*[]byte
+}
+
+// submit issues the write of p to the member at idx.
+func (w *inflight) submit(m *Member, idx int, p []byte) {
+	m.submit(OpWrite, idx, p, w.results)
+	w.active++
+}
+
+// fetch issues the read of the member at idx into p.
+func (w *inflight) fetch(m *Member, idx int, p []byte) {
+	m.submit(OpRead, idx, p, w.results)
+	w.active++
+}
+
+// hold keeps buf until every member has completed the request.
+func (w *inflight) hold(buf *[]byte) {
+	w.bufs = append(w.bufs, buf)
+}
+
+// inflights keeps the completed requests of a behavior for reuse.
+type inflights struct {
+	members int
+	free    []*inflight
+}
+
+// get returns an unused request.
+func (f *inflights) get() *inflight {
+	var w *inflight
+
+	if n := len(f.free); n > 0 {
+		w = f.free[n-1]
+		f.free = f.free[:n-1]
+	} else {
+		w = &inflight{results: make(chan rwT, f.members)}
+	}
+
+	w.active = 0
+	w.size = 0
+	w.p = nil
+	w.idx = -1
+	w.err = nil
+
+	return w
+}
+
+// put returns the buffers held by w to the pool and keeps w for reuse.
+func (f *inflights) put(w *inflight) {
+	for i, buf := range w.bufs {
+		putBuffer(buf)
+		w.bufs[i] = nil
+	}
+
+	w.bufs = w.bufs[:0]
+	w.p = nil
+
+	f.free = append(f.free, w)
+}
+
+// pipeline keeps the writes of a behavior in flight on its members. The
+// results of a write are collected by complete, which applies them to the
+// behavior as a synchronous write would have, in the order the writes were
+// issued. The pipeline must only be used with the behavior locked.
+type pipeline struct {
+	inflights
+
+	depth    int
+	complete func(w *inflight) (int, error)
+
+	queue []*inflight
+
+	// the error of a queued write that has not been reported yet, and whether
+	// the write was lost rather than written despite member failures
+	err  error
+	lost bool
+}
+
+func newPipeline(depth, members int, complete func(w *inflight) (int, error)) *pipeline {
+	return &pipeline{
+		inflights: inflights{members: members},
+		depth:     depth,
+		complete:  complete,
+	}
+}
+
+// begin returns a new write of p and the data to issue to the members. A
+// queued write must not depend on p, which the caller may reuse once Write
+// returns, so the data is copied to a buffer held by the write.
+func (pl *pipeline) begin(p []byte) (*inflight, []byte) {
+	w := pl.get()
+	w.size = len(p)
+
+	if pl.depth > 0 {
+		buf := getBuffer(len(p))
+		copy(*buf, p)
+
+		w.hold(buf)
+		p = *buf
+	}
+
+	return w, p
+}
+
+// end completes w right away unless writes are asynchronous. Otherwise w is
+// queued and the oldest writes are completed once more than depth writes are
+// queued. The error of a queued write is returned by the next call, along with
+// the size of w, which is queued regardless.
+func (pl *pipeline) end(w *inflight) (int, error) {
+	if pl.depth <= 0 {
+		return pl.finish(w)
+	}
+
+	pl.queue = append(pl.queue, w)
+
+	for len(pl.queue) > pl.depth {
+		pl.next()
+	}
+
+	if err := pl.err; err != nil {
+		pl.err, pl.lost = nil, false
+		return w.size, err
+	}
+
+	return w.size, nil
+}
+
+// abort releases a write that was not issued to any member.
+func (pl *pipeline) abort(w *inflight) {
+	pl.put(w)
+}
+
+// next completes the oldest queued write, recording its error. The error of
+// a lost write takes precedence over member failures the array survived.
+func (pl *pipeline) next() {
+	w := pl.queue[0]
+
+	copy(pl.queue, pl.queue[1:])
+	pl.queue[len(pl.queue)-1] = nil
+	pl.queue = pl.queue[:len(pl.queue)-1]
+
+	size := w.size
+
+	n, err := pl.finish(w)
+	if err == nil || pl.lost {
+		return
+	}
+
+	if pl.err == nil || n < size {
+		pl.err, pl.lost = err, n < size
+	}
+}
+
+// finish collects the results of w and releases its buffers.
+func (pl *pipeline) finish(w *inflight) (int, error) {
+	n, err := pl.complete(w)
+	pl.put(w)
+
+	return n, err
+}
+
+// flush completes every queued write and returns the first error among them
+// that has not been reported yet.
+func (pl *pipeline) flush() error {
+	if pl == nil {
+		return nil
+	}
+
+	for len(pl.queue) > 0 {
+		pl.next()
+	}
+
+	err := pl.err
+	pl.err, pl.lost = nil, false
+
+	return err
+}
+
+// flushMembers completes the writes queued on members with pl and syncs the
+// usable members to stable storage. It returns the first error among them
+// that Write has not reported.
+func flushMembers(pl *pipeline, members []*Member) error {
+	err := pl.flush()
+
+	for _, m := range members {
+		if !m.usable() {
+			continue
+		}
+
+		if serr := m.sync(); serr != nil && err == nil {
+			err = m.wrap(OpWrite, m.pos, serr)
+		}
+	}
+
+	return err
+}
+
+// Flush waits for the writes queued on the members to complete, syncs the
+// members to stable storage and returns the first error Write has not
+// reported. Data that does not fill a stripe is held back until Close.
+func (s *Stripe) Flush() error {
+	return flushMembers(s.pipe, s.ios)
+}
+
+// Flush waits for the writes queued on the members to complete, syncs the
+// members to stable storage and returns the first error Write has not
+// reported.
+func (m *Mirror) Flush() error {
+	return flushMembers(m.pipe, m.ios)
+}
+
+// Flush waits for the writes queued on the members to complete, syncs the
+// members to stable storage and returns the first error Write has not
+// reported. Data that does not fill a stripe is held back until Close.
+func (dp *DedicatedParity) Flush() error {
+	return flushMembers(dp.pipe, dp.Members())
+}
+
+// Flush waits for the writes queued on the members to complete, syncs the
+// members to stable storage and returns the first error Write has not
+// reported. Data that does not fill a stripe is held back until Close.
+func (dp *DistributedParity) Flush() error {
+	return flushMembers(dp.pipe, dp.ios)
+}
+
+// Flush waits for the writes queued on the members to complete, syncs the
+// members to stable storage and returns the first error Write has not
+// reported. Data that does not fill a stripe is held back until Close.
+func (dp *DualParity) Flush() error {
+	return flushMembers(dp.pipe, dp.ios)
+}
+
+// Flush waits for the writes queued on the members to complete, syncs the
+// members to stable storage and returns the first error Write has not
+// reported. Data that does not fill a stripe is held back until Close.
+func (ec *ErasureCoded) Flush() error {
+	return flushMembers(ec.pipe, ec.ios)
+}
@@ -0,0 +1,232 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+func TestMirrorQueueDepth(t *testing.T) {
+	slow := newHungDevice(1 << 20)
+
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{testutil.NewBlockDevice(1 << 20), slow},
+		streammux.WithQueueDepth(4),
+	)
+
+	data := make([]byte, 1<<14)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+
+	// the writes are queued on the slow member, which completes none of them
+	// until it is released
+	slow.hang = true
+
+	done := make(chan error, 1)
+
+	go func() {
+		for p := data; len(p) > 0; p = p[4096:] {
+			if _, err := m.Write(p[:4096]); err != nil {
+				done <- err
+				return
+			}
+		}
+
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the writes to return before the slow member completed them")
+	}
+
+	close(slow.release)
+
+	// the writes survived by the mirror report the failure of the member
+	var merr *streammux.MemberError
+	if err := m.Flush(); !errors.As(err, &merr) || merr.Member != 1 {
+		t.Fatalf("expected the failure of the slow member, got %v", err)
+	}
+
+	if m.Health() != streammux.DEGRADED || m.Members()[1].State() != streammux.FAILED {
+		t.Fatal("expected the slow member to fail and the mirror to be DEGRADED")
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+	defer m.Close()
+
+	got, err := io.ReadAll(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read differs from the data written")
+	}
+}
+
+func TestStripeQueueDepthError(t *testing.T) {
+	s := streammux.NewStripe([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 4),
+	}, streammux.WithStripeUnit(512), streammux.WithQueueDepth(2))
+
+	data := make([]byte, 1<<14)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Open()
+	defer s.Close()
+
+	// the failed write is reported by a later Write or by Flush
+	var err error
+
+	for p := data; len(p) > 0 && err == nil; p = p[1024:] {
+		_, err = s.Write(p[:1024])
+	}
+
+	if err == nil {
+		err = s.Flush()
+	}
+
+	if !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected the write error to be reported, got %v", err)
+	}
+
+	if s.Health() != streammux.FAILED {
+		t.Fatal("expected the stripe to fail")
+	}
+
+	// the writes queued behind the failed one fail as well and are reported
+	// by the next Flush, after which no error is left to report
+	s.Flush()
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("expected no more errors, got %v", err)
+	}
+}
+
+// slowFaultyDevice takes delay for every write and fails every write once
+// failAfter writes have succeeded.
+type slowFaultyDevice struct {
+	*testutil.BlockDevice
+
+	delay     time.Duration
+	writes    int
+	failAfter int
+}
+
+func (d *slowFaultyDevice) Write(p []byte) (int, error) {
+	time.Sleep(d.delay)
+
+	if d.writes >= d.failAfter {
+		return 0, syscall.EIO
+	}
+
+	d.writes++
+
+	return d.BlockDevice.Write(p)
+}
+
+func TestDedicatedParityQueueDepthFailure(t *testing.T) {
+	dp := streammux.NewDedicatedParity(testutil.NewBlockDevice(1<<20), []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		&slowFaultyDevice{BlockDevice: testutil.NewBlockDevice(1 << 20), delay: 2 * time.Millisecond, failAfter: 20},
+		testutil.NewBlockDevice(1 << 20),
+	}, streammux.WithStripeUnit(512), streammux.WithQueueDepth(4))
+
+	data := make([]byte, 3072*20)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+
+	// the writes queued to the member when it fails fail as well, but the
+	// member only fails once
+	for p := data; len(p) > 0; p = p[3072:] {
+		if _, err := dp.Write(p[:3072]); errors.Is(err, streammux.ErrArrayFailed) {
+			t.Fatal(err)
+		}
+	}
+
+	if err := dp.Flush(); errors.Is(err, streammux.ErrArrayFailed) {
+		t.Fatal(err)
+	}
+
+	if dp.Health() != streammux.DEGRADED || dp.Members()[1].State() != streammux.FAILED {
+		t.Fatal("expected the member to fail and the array to be DEGRADED")
+	}
+
+	if err := dp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	defer dp.Close()
+
+	if got := readAll(t, dp, 4096); !bytes.Equal(data, got) {
+		t.Fatal("data reconstructed without the failed member differs from the data written")
+	}
+}
+
+// syncingDevice counts the times it is synced and fails the syncs with err.
+type syncingDevice struct {
+	*testutil.BlockDevice
+	syncs int
+	err   error
+}
+
+func (d *syncingDevice) Sync() error {
+	d.syncs++
+	return d.err
+}
+
+func TestFlushSync(t *testing.T) {
+	devs := []*syncingDevice{
+		{BlockDevice: testutil.NewBlockDevice(1 << 20)},
+		{BlockDevice: testutil.NewBlockDevice(1 << 20), err: syscall.EIO},
+	}
+
+	s := streammux.NewStripe([]io.ReadWriteCloser{devs[0], devs[1]},
+		streammux.WithStripeUnit(4096),
+		streammux.WithQueueDepth(4),
+	)
+
+	s.Open()
+	defer s.Close()
+
+	if _, err := s.Write(make([]byte, 1<<14)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the members are synced once the queued writes have completed
+	var merr *streammux.MemberError
+	if err := s.Flush(); !errors.As(err, &merr) || merr.Member != 1 || !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected the failed sync of member 1, got %v", err)
+	}
+
+	for i, dev := range devs {
+		if dev.syncs != 1 {
+			t.Fatalf("expected member %d to be synced once, got %d syncs", i, dev.syncs)
+		}
+	}
+}
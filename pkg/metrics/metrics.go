@@ -0,0 +1,239 @@
+// Package metrics collects the I/O of streammux members and exports it in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bh107/streammux"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the latency histogram
+// buckets. They range from fast disks to tape drives repositioning.
+var DefaultBuckets = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5, 10, 30}
+
+type memberKey struct {
+	array  streammux.UUID
+	member int
+	op     streammux.Op
+}
+
+type memberStats struct {
+	bytes  uint64
+	ops    uint64
+	errors uint64
+
+	// number of operations per latency bucket, the last bucket counting the
+	// operations slower than every bound, and the total latency in seconds
+	buckets []uint64
+	sum     float64
+}
+
+type namedArray struct {
+	name string
+	a    streammux.Array
+
+	// a member of the array, nil if it has none. The members of an array may
+	// be replaced while it is in use, but they all share its identity.
+	member *streammux.Member
+}
+
+type namedPool struct {
+	name string
+	sp   *streammux.SparePool
+}
+
+// Registry implements streammux.Metrics. It counts the bytes, operations and
+// errors of every member and records their latency in a histogram. Arrays and
+// spare pools registered with it are exported as gauges.
+type Registry struct {
+	mu sync.Mutex
+
+	bounds  []float64
+	members map[memberKey]*memberStats
+
+	arrays []namedArray
+	pools  []namedPool
+}
+
+// NewRegistry returns a registry using DefaultBuckets.
+func NewRegistry() *Registry {
+	return NewRegistryWithBuckets(DefaultBuckets)
+}
+
+// NewRegistryWithBuckets returns a registry recording latencies in histogram
+// buckets with the given upper bounds in seconds, which must be increasing.
+func NewRegistryWithBuckets(bounds []float64) *Registry {
+	return &Registry{
+		bounds:  bounds,
+		members: make(map[memberKey]*memberStats),
+	}
+}
+
+// ObserveMemberOp records op.
+func (r *Registry) ObserveMemberOp(op streammux.MemberOp) {
+	seconds := op.Latency.Seconds()
+	bucket := sort.SearchFloat64s(r.bounds, seconds)
+
+	key := memberKey{op.Array, op.Member, op.Op}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats, ok := r.members[key]
+	if !ok {
+		stats = &memberStats{
+			buckets: make([]uint64, len(r.bounds)+1),
+		}
+
+		r.members[key] = stats
+	}
+
+	stats.bytes += uint64(op.Bytes)
+	stats.ops++
+
+	if op.Err != nil && op.Err != io.EOF {
+		stats.errors++
+	}
+
+	stats.buckets[bucket]++
+	stats.sum += seconds
+}
+
+// RegisterArray exports the health of a under name. The members of a are
+// labeled with name rather than the UUID of the array. It must not be called
+// while a member of a is being replaced.
+func (r *Registry) RegisterArray(name string, a streammux.Array) {
+	na := namedArray{name: name, a: a}
+	if members := a.Members(); len(members) > 0 {
+		na.member = members[0]
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.arrays = append(r.arrays, na)
+}
+
+// RegisterSparePool exports the number of spares left in sp under name.
+func (r *Registry) RegisterSparePool(name string, sp *streammux.SparePool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pools = append(r.pools, namedPool{name, sp})
+}
+
+// WriteTo writes every metric to w in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+
+	r.mu.Lock()
+	r.write(&buf)
+	r.mu.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func header(buf *bytes.Buffer, name, typ, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// write formats the metrics into buf. The registry must be locked.
+func (r *Registry) write(buf *bytes.Buffer) {
+	// members of registered arrays are labeled with the name of the array
+	names := make(map[streammux.UUID]string)
+	for _, na := range r.arrays {
+		// the identity is adopted when the array is opened, so it is looked
+		// up on every scrape
+		if na.member != nil {
+			names[na.member.ArrayUUID()] = na.name
+		}
+	}
+
+	keys := make([]memberKey, 0, len(r.members))
+	labels := make(map[memberKey]string, len(r.members))
+
+	for key := range r.members {
+		name, ok := names[key.array]
+		if !ok {
+			name = key.array.String()
+		}
+
+		keys = append(keys, key)
+		labels[key] = fmt.Sprintf(`array="%s",member="%d",op="%s"`, escaper.Replace(name), key.member, key.op)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return labels[keys[i]] < labels[keys[j]]
+	})
+
+	counters := []struct {
+		name, help string
+		value      func(*memberStats) uint64
+	}{
+		{"streammux_member_bytes_total", "Bytes transferred by member operations.", func(s *memberStats) uint64 { return s.bytes }},
+		{"streammux_member_ops_total", "Operations issued to members.", func(s *memberStats) uint64 { return s.ops }},
+		{"streammux_member_errors_total", "Member operations that failed.", func(s *memberStats) uint64 { return s.errors }},
+	}
+
+	for _, c := range counters {
+		header(buf, c.name, "counter", c.help)
+
+		for _, key := range keys {
+			fmt.Fprintf(buf, "%s{%s} %d\n", c.name, labels[key], c.value(r.members[key]))
+		}
+	}
+
+	const latency = "streammux_member_latency_seconds"
+
+	header(buf, latency, "histogram", "Latency of member operations.")
+
+	for _, key := range keys {
+		stats := r.members[key]
+
+		var cumulative uint64
+		for i, bound := range r.bounds {
+			cumulative += stats.buckets[i]
+			fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n", latency, labels[key], formatFloat(bound), cumulative)
+		}
+
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", latency, labels[key], stats.ops)
+		fmt.Fprintf(buf, "%s_sum{%s} %s\n", latency, labels[key], formatFloat(stats.sum))
+		fmt.Fprintf(buf, "%s_count{%s} %d\n", latency, labels[key], stats.ops)
+	}
+
+	if len(r.arrays) > 0 {
+		header(buf, "streammux_array_state", "gauge", "Health of arrays: 0 OK, 1 DEGRADED, 4 FAILED.")
+
+		for _, na := range r.arrays {
+			fmt.Fprintf(buf, "streammux_array_state{array=\"%s\"} %d\n", escaper.Replace(na.name), na.a.Health())
+		}
+	}
+
+	if len(r.pools) > 0 {
+		header(buf, "streammux_spare_pool_available", "gauge", "Spares left in spare pools.")
+
+		for _, np := range r.pools {
+			fmt.Fprintf(buf, "streammux_spare_pool_available{pool=\"%s\"} %d\n", escaper.Replace(np.name), np.sp.Available())
+		}
+	}
+}
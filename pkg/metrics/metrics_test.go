@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+
+	sp := streammux.NewSparePool([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	})
+
+	s := streammux.NewStripe([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 1),
+	}, streammux.WithStripeUnit(4096), streammux.WithSparePool(sp), streammux.WithMetrics(reg))
+
+	reg.RegisterArray("backup", s)
+	reg.RegisterSparePool("spares", sp)
+
+	data := make([]byte, 1<<16)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Open()
+
+	if _, err := s.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Open()
+
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read differs from the data written")
+	}
+
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+
+	// eight chunks and half of the stream trailer per member
+	for _, want := range []string{
+		`streammux_member_ops_total{array="backup",member="0",op="write"} 9`,
+		`streammux_member_bytes_total{array="backup",member="0",op="write"} 32772`,
+		`streammux_member_bytes_total{array="backup",member="1",op="read"} 32772`,
+		`streammux_member_errors_total{array="backup",member="1",op="write"} 0`,
+		`streammux_member_latency_seconds_count{array="backup",member="0",op="read"}`,
+		`streammux_member_latency_seconds_bucket{array="backup",member="0",op="read",le="+Inf"}`,
+		`streammux_array_state{array="backup"} 0`,
+		`streammux_spare_pool_available{pool="spares"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected the output to contain %s, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestRegistryHistogram(t *testing.T) {
+	reg := NewRegistryWithBuckets([]float64{.001, .01})
+
+	var uuid streammux.UUID
+
+	for _, latency := range []time.Duration{500 * time.Microsecond, 5 * time.Millisecond, time.Second} {
+		reg.ObserveMemberOp(streammux.MemberOp{
+			Array:   uuid,
+			Member:  0,
+			Op:      streammux.OpWrite,
+			Bytes:   512,
+			Latency: latency,
+		})
+	}
+
+	reg.ObserveMemberOp(streammux.MemberOp{
+		Array: uuid,
+		Op:    streammux.OpWrite,
+		Err:   io.ErrShortWrite,
+	})
+
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := `array="` + uuid.String() + `",member="0",op="write"`
+
+	for _, want := range []string{
+		`streammux_member_latency_seconds_bucket{` + labels + `,le="0.001"} 2`,
+		`streammux_member_latency_seconds_bucket{` + labels + `,le="0.01"} 3`,
+		`streammux_member_latency_seconds_bucket{` + labels + `,le="+Inf"} 4`,
+		`streammux_member_latency_seconds_sum{` + labels + `} 1.0055`,
+		`streammux_member_errors_total{` + labels + `} 1`,
+		`streammux_member_bytes_total{` + labels + `} 1536`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected the output to contain %s, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestRegistryScrape(t *testing.T) {
+	reg := NewRegistry()
+
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 8),
+	}, streammux.WithMetrics(reg))
+
+	reg.RegisterArray("backup", m)
+
+	done := make(chan struct{})
+	scraped := make(chan struct{})
+
+	// the health of the array is scraped while it changes
+	go func() {
+		defer close(scraped)
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			if _, err := reg.WriteTo(io.Discard); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	p := make([]byte, 4096)
+
+	for i := 0; i < 4; i++ {
+		m.Open()
+
+		// the failure of the member is reported along with the data written
+		for j := 0; j < 8; j++ {
+			n, err := m.Write(p)
+
+			var merr *streammux.MemberError
+			if n != len(p) || err != nil && (!errors.As(err, &merr) || merr.Member != 1) {
+				t.Fatal(err)
+			}
+		}
+
+		m.Close()
+	}
+
+	close(done)
+	<-scraped
+
+	if m.Health() != streammux.DEGRADED {
+		t.Fatal("expected the mirror to be DEGRADED")
+	}
+}
@@ -5,10 +5,20 @@ import (
 	"syscall"
 )
 
+// BlockDevice is an in-memory device that behaves like a tape. A blank device
+// holds no data, such that reading it ends right away, as reading a blank
+// member must to be told apart from reading one that holds a stream. The data
+// ends where the device was last written up to, which lets data be read back
+// by seeking rather than closing, and reading does not move the end.
 type BlockDevice struct {
 	buf []byte
+
+	// the end of the data and the current position
 	eof int
 	pos int
+
+	// whether the device was written since it was last closed
+	dirty bool
 }
 
 type FaultyDevice struct {
@@ -39,21 +49,27 @@ func (blk *FaultyDevice) Close() error {
 	return blk.BlockDevice.Close()
 }
 
+// NewBlockDevice returns a blank device of the given size.
 func NewBlockDevice(size int) *BlockDevice {
 	return &BlockDevice{
 		buf: make([]byte, size),
-		eof: -1,
 	}
 }
 
+// Close rewinds the device. Like a tape, a device that was written ends at the
+// position it was closed at.
 func (blk *BlockDevice) Close() error {
-	blk.eof = blk.pos
+	if blk.dirty {
+		blk.eof = blk.pos
+		blk.dirty = false
+	}
+
 	blk.pos = 0
 	return nil
 }
 
 func (blk *BlockDevice) Read(p []byte) (n int, err error) {
-	if blk.pos == blk.eof {
+	if blk.pos >= blk.eof {
 		return n, io.EOF
 	}
 
@@ -62,6 +78,11 @@ func (blk *BlockDevice) Read(p []byte) (n int, err error) {
 		newpos = len(blk.buf)
 	}
 
+	// don't read past the end of the data
+	if newpos > blk.eof {
+		newpos = blk.eof
+	}
+
 	n = copy(p, blk.buf[blk.pos:newpos])
 	blk.pos += n
 
@@ -81,6 +102,11 @@ func (blk *BlockDevice) Write(p []byte) (n int, err error) {
 
 	n = copy(blk.buf[blk.pos:newpos], p)
 	blk.pos += n
+	blk.dirty = true
+
+	if blk.pos > blk.eof {
+		blk.eof = blk.pos
+	}
 
 	if n < len(p) {
 		return n, syscall.ENOSPC
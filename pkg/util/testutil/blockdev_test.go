@@ -63,3 +63,70 @@ func TestBlockdev(t *testing.T) {
 		t.Fatalf("data mismatch: expected \"loco\", got %s", string(data))
 	}
 }
+
+func TestBlockdevReadPastEOF(t *testing.T) {
+	blkdev := NewBlockDevice(64)
+
+	if _, err := blkdev.Write([]byte("foobar")); err != nil {
+		t.Fatal(err)
+	}
+
+	blkdev.Close()
+
+	p := make([]byte, 16)
+
+	n, err := blkdev.Read(p)
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+
+	if n != len("foobar") || string(p[:n]) != "foobar" {
+		t.Fatalf("expected to read \"foobar\", got %q", string(p[:n]))
+	}
+}
+
+func TestBlockdevCloseAfterRead(t *testing.T) {
+	blkdev := NewBlockDevice(64)
+
+	if _, err := blkdev.Read(make([]byte, 8)); err != io.EOF {
+		t.Fatal("expected a blank device to be at EOF")
+	}
+
+	if _, err := blkdev.Write([]byte("foobar")); err != nil {
+		t.Fatal(err)
+	}
+
+	blkdev.Close()
+
+	if _, err := blkdev.Read(make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	// closing after a read must not move the end of the data
+	blkdev.Close()
+
+	p := make([]byte, 16)
+
+	n, _ := blkdev.Read(p)
+	if string(p[:n]) != "foobar" {
+		t.Fatalf("expected to read \"foobar\", got %q", string(p[:n]))
+	}
+}
+
+func TestBlockdevSeekAfterWrite(t *testing.T) {
+	blkdev := NewBlockDevice(64)
+
+	if _, err := blkdev.Write([]byte("foobar")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data written is readable without closing the device
+	blkdev.Seek(0, io.SeekStart)
+
+	p := make([]byte, 16)
+
+	n, err := blkdev.Read(p)
+	if err != io.EOF || string(p[:n]) != "foobar" {
+		t.Fatalf("expected to read \"foobar\" up to EOF, got %q, %v", string(p[:n]), err)
+	}
+}
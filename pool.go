@@ -0,0 +1,57 @@
+package streammux
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Buffers used for member I/O are pooled in power of two size classes from
+// 512 bytes to 16 MiB. Larger buffers are allocated for the request and left
+// to the garbage collector.
+const (
+	minBufferShift = 9
+	maxBufferShift = 24
+)
+
+var buffers [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// bufferClass returns the size class holding buffers of n bytes.
+func bufferClass(n int) int {
+	shift := bits.Len(uint(n - 1))
+	if shift < minBufferShift {
+		shift = minBufferShift
+	}
+
+	return shift - minBufferShift
+}
+
+// getBuffer returns a buffer of n bytes from the pool. Its contents are
+// undefined. The buffer is handed out by pointer such that returning it does
+// not allocate.
+func getBuffer(n int) *[]byte {
+	class := bufferClass(n)
+	if class >= len(buffers) {
+		p := make([]byte, n)
+		return &p
+	}
+
+	if buf, ok := buffers[class].Get().(*[]byte); ok {
+		*buf = (*buf)[:n]
+		return buf
+	}
+
+	p := make([]byte, n, 1<<(class+minBufferShift))
+
+	return &p
+}
+
+// putBuffer returns a buffer obtained from getBuffer to the pool. Neither the
+// buffer nor any slice of it may be used afterwards.
+func putBuffer(buf *[]byte) {
+	class := bufferClass(cap(*buf))
+	if class >= len(buffers) || cap(*buf) != 1<<(class+minBufferShift) {
+		return
+	}
+
+	buffers[class].Put(buf)
+}
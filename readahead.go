@@ -0,0 +1,212 @@
+package streammux
+
+// WithReadAhead keeps up to n stripes read ahead of the caller on the members
+// of a behavior, such that the members keep streaming while the caller
+// consumes the data. Mirror and Concat, which do not stripe, read ahead n
+// blocks of the stripe unit. A chunk that is missing or corrupt is
+// reconstructed as the stripe read ahead is consumed.
+//
+// ReadContext waits for the reads it issues ahead to complete before it
+// returns. With n = 0, the default, every read waits for all members.
+func WithReadAhead(n int) MemberOption {
+	return func(o *memberOptions) {
+		o.readAhead = n
+	}
+}
+
+// readahead keeps the reads of a behavior in flight on its members. A read is
+// submitted to the members by issue and its results are collected by
+// complete, which applies them to the behavior as a synchronous read would
+// have, in the order the reads were issued. The readahead must only be used
+// with the behavior locked.
+type readahead struct {
+	inflights
+
+	window   int
+	issue    func(r *inflight) error
+	complete func(r *inflight) (int, error)
+
+	// reads issued ahead of the caller, oldest first
+	queue []*inflight
+
+	// the block consumed by stream, the part of it not yet returned and the
+	// error that ended the reads ahead
+	block []byte
+	data  []byte
+	err   error
+
+	// results of a read being settled
+	settled []rwT
+}
+
+func newReadahead(window, members int, issue func(r *inflight) error, complete func(r *inflight) (int, error)) *readahead {
+	return &readahead{
+		inflights: inflights{members: members},
+		window:    window,
+		issue:     issue,
+		complete:  complete,
+	}
+}
+
+// start issues a read into p.
+func (ra *readahead) start(p []byte) *inflight {
+	r := ra.get()
+	r.p = p
+	r.err = ra.issue(r)
+
+	return r
+}
+
+// finish collects the results of r. An error issuing r is returned as is.
+func (ra *readahead) finish(r *inflight) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	return ra.complete(r)
+}
+
+// direct reads into p without reading ahead.
+func (ra *readahead) direct(p []byte) (int, error) {
+	r := ra.start(p)
+
+	n, err := ra.finish(r)
+	ra.put(r)
+
+	return n, err
+}
+
+// next completes the oldest read issued ahead and copies it to p. Reads of
+// len(p) bytes are issued first, such that window reads remain ahead of it.
+func (ra *readahead) next(p []byte) (int, error) {
+	for len(ra.queue) <= ra.window {
+		buf := getBuffer(len(p))
+
+		r := ra.start(*buf)
+		r.hold(buf)
+
+		ra.queue = append(ra.queue, r)
+	}
+
+	r := ra.queue[0]
+
+	copy(ra.queue, ra.queue[1:])
+	ra.queue[len(ra.queue)-1] = nil
+	ra.queue = ra.queue[:len(ra.queue)-1]
+
+	n, err := ra.finish(r)
+
+	// a short stripe is copied as a whole, as its chunks are compacted by
+	// the striper
+	copy(p, r.p)
+	ra.put(r)
+
+	return n, err
+}
+
+// read reads the next stripe into p. The reads issued ahead of a stripe that
+// ends the stream or fails are discarded.
+func (ra *readahead) read(p []byte) (int, error) {
+	if ra.window <= 0 {
+		return ra.direct(p)
+	}
+
+	n, err := ra.next(p)
+	if err != nil || n < len(p) {
+		ra.discard()
+	}
+
+	return n, err
+}
+
+// stream reads into p from blocks of size bytes read ahead, or of
+// DefaultStripeUnit bytes if size is not positive. The end of the stream or
+// an error is returned once the data read before it is consumed.
+func (ra *readahead) stream(p []byte, size int) (n int, err error) {
+	if ra.window <= 0 {
+		return ra.direct(p)
+	}
+
+	if size <= 0 {
+		size = DefaultStripeUnit
+	}
+
+	for n < len(p) {
+		if len(ra.data) > 0 {
+			k := copy(p[n:], ra.data)
+			ra.data = ra.data[k:]
+			n += k
+
+			continue
+		}
+
+		if ra.err != nil {
+			break
+		}
+
+		if cap(ra.block) < size {
+			ra.block = make([]byte, size)
+		}
+
+		k, err := ra.next(ra.block[:size])
+		ra.data = ra.block[:k]
+
+		if err != nil {
+			ra.err = err
+			ra.discard()
+		}
+	}
+
+	if n > 0 {
+		return n, nil
+	}
+
+	err, ra.err = ra.err, nil
+
+	return 0, err
+}
+
+// discard waits for the reads issued ahead and drops them.
+func (ra *readahead) discard() {
+	for i, r := range ra.queue {
+		for j := 0; j < r.active; j++ {
+			<-r.results
+		}
+
+		ra.put(r)
+		ra.queue[i] = nil
+	}
+
+	ra.queue = ra.queue[:0]
+}
+
+// reset discards the reads issued ahead and the data not yet returned.
+func (ra *readahead) reset() {
+	ra.discard()
+
+	ra.data = nil
+	ra.err = nil
+}
+
+// settle waits for the reads issued ahead to complete, such that no read is
+// in progress on the members. The results are put back for complete.
+func (ra *readahead) settle() {
+	if ra == nil {
+		return
+	}
+
+	for _, r := range ra.queue {
+		settled := ra.settled[:0]
+
+		for i := 0; i < r.active; i++ {
+			settled = append(settled, <-r.results)
+		}
+
+		for i, rc := range settled {
+			r.results <- rc
+			settled[i] = rwT{}
+		}
+
+		ra.settled = settled[:0]
+	}
+}
@@ -0,0 +1,198 @@
+package streammux_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+// readFaultDevice fails every read once failAfter reads have succeeded. A
+// negative failAfter never fails.
+type readFaultDevice struct {
+	*testutil.BlockDevice
+
+	reads     int
+	failAfter int
+}
+
+func (d *readFaultDevice) Read(p []byte) (int, error) {
+	if d.failAfter >= 0 && d.reads >= d.failAfter {
+		return 0, syscall.EIO
+	}
+
+	d.reads++
+
+	return d.BlockDevice.Read(p)
+}
+
+// readAll reads r to the end with reads of size bytes.
+func readAll(t *testing.T, r io.Reader, size int) []byte {
+	var got []byte
+
+	p := make([]byte, size)
+
+	for {
+		n, err := r.Read(p)
+		got = append(got, p[:n]...)
+
+		if err == io.EOF {
+			return got
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadAhead(t *testing.T) {
+	opts := []streammux.MemberOption{
+		streammux.WithStripeUnit(512),
+		streammux.WithReadAhead(4),
+	}
+
+	// Concat is given devices smaller than the data, such that it reads
+	// ahead across members
+	arrays := map[string]struct {
+		newArray func([]io.ReadWriteCloser) streammux.Array
+		size     int
+	}{
+		"Mirror": {func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return streammux.NewMirrorWithOptions(rwcs, opts...)
+		}, 1 << 18},
+		"Stripe": {func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return streammux.NewStripe(rwcs, opts...)
+		}, 1 << 16},
+		"Concat": {func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return streammux.NewConcat(rwcs, opts...)
+		}, 1 << 15},
+		"DedicatedParity": {func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return streammux.NewDedicatedParity(rwcs[0], rwcs[1:], opts...)
+		}, 1 << 16},
+		"DistributedParity": {func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return streammux.NewDistributedParity(rwcs, opts...)
+		}, 1 << 16},
+		"DualParity": {func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return newDualParity(t, rwcs[0], rwcs[1], rwcs[2:], opts...)
+		}, 1 << 16},
+		"ErasureCoded": {func(rwcs []io.ReadWriteCloser) streammux.Array {
+			return newErasureCoded(t, rwcs[:3], rwcs[3:], opts...)
+		}, 1 << 16},
+	}
+
+	data := make([]byte, 1<<16+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range arrays {
+		t.Run(name, func(t *testing.T) {
+			rwcs := make([]io.ReadWriteCloser, 5)
+			for i := range rwcs {
+				rwcs[i] = testutil.NewBlockDevice(tc.size)
+			}
+
+			a := tc.newArray(rwcs)
+
+			a.Open()
+			writeArray(t, a, data)
+
+			a.Open()
+			defer a.Close()
+
+			// reads smaller than a stripe are served from the stripes read
+			// ahead
+			if got := readAll(t, a, 1000); !bytes.Equal(data, got) {
+				t.Fatal("data read ahead differs from the data written")
+			}
+		})
+	}
+}
+
+func TestDedicatedParityReadAheadFailure(t *testing.T) {
+	faulty := &readFaultDevice{BlockDevice: testutil.NewBlockDevice(1 << 20), failAfter: -1}
+
+	dp := streammux.NewDedicatedParity(testutil.NewBlockDevice(1<<20), []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		faulty,
+		testutil.NewBlockDevice(1 << 20),
+	}, streammux.WithStripeUnit(512), streammux.WithReadAhead(8))
+
+	data := make([]byte, 1<<16)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	writeArray(t, dp, data)
+
+	// the member fails with stripes read ahead of the failed one still
+	// queued on it
+	faulty.failAfter = 5
+
+	dp.Open()
+	defer dp.Close()
+
+	if got := readAll(t, dp, 4096); !bytes.Equal(data, got) {
+		t.Fatal("data reconstructed without the failed member differs from the data written")
+	}
+
+	if dp.Health() != streammux.DEGRADED || dp.Members()[1].State() != streammux.FAILED {
+		t.Fatal("expected the member to fail and the array to be DEGRADED")
+	}
+}
+
+func TestStripeReadAheadContext(t *testing.T) {
+	s := streammux.NewStripe([]io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}, streammux.WithStripeUnit(512), streammux.WithReadAhead(4))
+
+	data := make([]byte, 1<<15)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Open()
+	writeArray(t, s, data)
+
+	s.Open()
+	defer s.Close()
+
+	got := make([]byte, 0, len(data))
+	p := make([]byte, 1000)
+
+	for {
+		// the reads issued ahead complete before ReadContext returns, such
+		// that cancelling the context afterwards does not affect them
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		n, err := s.ReadContext(ctx, p)
+		cancel()
+
+		got = append(got, p[:n]...)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read differs from the data written")
+	}
+
+	if s.Health() != streammux.OK {
+		t.Fatal("expected the stripe to remain OK")
+	}
+}
@@ -0,0 +1,424 @@
+package streammux
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// rebuildChunkSize is the number of bytes copied at a time by a rebuild.
+const rebuildChunkSize = 64 << 10
+
+// WithRebuildRate limits the bandwidth used to rebuild replaced members to
+// bytesPerSecond. By default rebuilds are not limited.
+func WithRebuildRate(bytesPerSecond int64) MemberOption {
+	return func(o *memberOptions) {
+		o.rebuildRate = bytesPerSecond
+	}
+}
+
+// RebuildJob is the rebuild of a replaced member.
+type RebuildJob struct {
+	mu sync.Mutex
+
+	ctx     context.Context
+	idx     int
+	rate    int64
+	journal string
+
+	// bytes copied and bytes to copy (zero if unknown), as stored on the
+	// members, and the bytes already copied when the copy was (re)started
+	done    int64
+	total   int64
+	base    int64
+	started time.Time
+
+	// the offset the copy continues from and the generation of the stream
+	// being copied
+	resume     int64
+	generation uint64
+	checkpoint time.Time
+
+	// the members read and the member being rebuilt while the rebuild holds
+	// them open
+	srcs []*Member
+	dst  *Member
+	open bool
+
+	err      error
+	finished chan struct{}
+}
+
+func newRebuildJob(ctx context.Context, idx int, opts memberOptions) *RebuildJob {
+	return &RebuildJob{
+		ctx:      ctx,
+		idx:      idx,
+		rate:     opts.rebuildRate,
+		journal:  opts.journal,
+		finished: make(chan struct{}),
+	}
+}
+
+// Progress returns the number of bytes copied and the total number of bytes
+// to copy. The total is zero if it is not known.
+func (job *RebuildJob) Progress() (done, total int64) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return job.done, job.total
+}
+
+// ETA returns the estimated time until the rebuild finishes. It is zero if
+// the total is not known or nothing has been copied yet.
+func (job *RebuildJob) ETA() time.Duration {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	copied := job.done - job.base
+	if job.total == 0 || copied <= 0 {
+		return 0
+	}
+
+	elapsed := time.Since(job.started)
+
+	return time.Duration(float64(elapsed) * float64(job.total-job.done) / float64(copied))
+}
+
+// Done returns a channel that is closed when the rebuild has finished.
+func (job *RebuildJob) Done() <-chan struct{} {
+	return job.finished
+}
+
+// Err returns the error that ended the rebuild, if any, once it has finished.
+func (job *RebuildJob) Err() error {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return job.err
+}
+
+// start opens a source member and the member being rebuilt and positions
+// both at the offset the copy continues from. The copy starts over if a new
+// stream has been written since. The mirror must be locked.
+func (job *RebuildJob) start(m *Mirror) error {
+	for i, member := range m.ios {
+		if i != job.idx && member.State() == OK {
+			job.begin([]*Member{member}, m.ios[job.idx], m.size)
+			return nil
+		}
+	}
+
+	return ErrNoRedundancy
+}
+
+// begin opens the members read by the rebuild and the member being rebuilt,
+// and positions all of them at the offset the copy continues from. The copy
+// starts over if a new stream has been written since. total is the number of
+// bytes to copy, if known. The behavior must be locked.
+func (job *RebuildJob) begin(srcs []*Member, dst *Member, total int64) {
+	job.srcs, job.dst = srcs, dst
+
+	job.reopen()
+	job.open = true
+
+	if generation := job.dst.array.currentGeneration(); generation != job.generation {
+		job.generation = generation
+		job.resume = 0
+	}
+
+	if job.resume > 0 {
+		if err := job.dst.skip(job.resume); err != nil {
+			job.resume = 0
+		}
+
+		for _, src := range job.srcs {
+			if job.resume == 0 {
+				break
+			}
+
+			if err := src.skip(job.resume); err != nil {
+				job.resume = 0
+			}
+		}
+
+		// reposition the members at the start
+		if job.resume == 0 {
+			job.close()
+			job.reopen()
+		}
+	}
+
+	job.mu.Lock()
+	job.done = job.resume
+	job.base = job.resume
+	job.total = total
+	job.started = time.Now()
+	job.mu.Unlock()
+}
+
+// reopen opens the members of the rebuild at their start.
+func (job *RebuildJob) reopen() {
+	for _, src := range job.srcs {
+		src.Open()
+	}
+
+	job.dst.Open()
+
+	// join the current generation rather than starting a new one
+	job.dst.SetState(REBUILDING)
+}
+
+// close closes the members of the rebuild.
+func (job *RebuildJob) close() {
+	for _, src := range job.srcs {
+		src.Close()
+	}
+
+	job.dst.Close()
+}
+
+// suspend closes the members held open by the rebuild, recording the offset
+// the copy is to continue from. The behavior must be locked.
+func (job *RebuildJob) suspend() {
+	if !job.open {
+		return
+	}
+
+	job.save()
+	job.close()
+
+	job.open = false
+}
+
+// save records the progress of the copy such that it can be continued from
+// there. The behavior must be locked.
+func (job *RebuildJob) save() {
+	if err := job.dst.sync(); err != nil {
+		return
+	}
+
+	job.mu.Lock()
+	job.resume = job.done
+	job.mu.Unlock()
+
+	if job.journal == "" {
+		return
+	}
+
+	cp := &checkpoint{
+		uuid:       job.dst.array.uuid,
+		index:      job.idx,
+		generation: job.generation,
+		offset:     job.resume,
+	}
+
+	if err := writeCheckpoint(job.journal, cp); err != nil {
+		log.Printf("writing rebuild checkpoint failed: %v", err)
+	}
+
+	job.checkpoint = time.Now()
+}
+
+// forget removes the checkpoint of the rebuild of dst once the rebuild has
+// ended, unless dst still awaits a rebuild that can continue from it.
+func (job *RebuildJob) forget(dst *Member) {
+	if job.journal == "" || dst.State() == REPLACED {
+		return
+	}
+
+	if err := removeCheckpoint(job.journal); err != nil {
+		log.Printf("removing rebuild checkpoint failed: %v", err)
+	}
+}
+
+// throttle waits until the rebuild is within its rate limit.
+func (job *RebuildJob) throttle() {
+	if job.rate <= 0 {
+		return
+	}
+
+	job.mu.Lock()
+	want := time.Duration(float64(job.done-job.base) / float64(job.rate) * float64(time.Second))
+	wait := want - time.Since(job.started)
+	job.mu.Unlock()
+
+	if wait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-job.ctx.Done():
+	}
+}
+
+// rebuilding reports whether the member is awaiting or undergoing a rebuild.
+func rebuilding(m *Member) bool {
+	return m.State() == REPLACED || m.State() == REBUILDING
+}
+
+// Rebuild returns the rebuild that is running or about to run, if any.
+func (m *Mirror) Rebuild() *RebuildJob {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.job
+}
+
+// resumeRebuild returns the rebuild recorded in the rebuild journal, if it
+// belongs to the array of members, and marks the member it rebuilds as
+// REPLACED. The behavior must be locked.
+func resumeRebuild(members []*Member, opts memberOptions) *RebuildJob {
+	if opts.journal == "" {
+		return nil
+	}
+
+	cp, err := readCheckpoint(opts.journal)
+	if err != nil || cp == nil {
+		return nil
+	}
+
+	a := members[0].array
+	if cp.uuid != a.uuid || cp.index >= len(members) || cp.generation != a.currentGeneration() {
+		return nil
+	}
+
+	members[cp.index].SetState(REPLACED)
+
+	job := newRebuildJob(context.Background(), cp.index, opts)
+	job.generation = cp.generation
+	job.resume = cp.offset
+
+	return job
+}
+
+// resume queues the rebuild recorded in the rebuild journal, if it belongs to
+// the mirror. The mirror must be locked.
+func (m *Mirror) resume() {
+	if job := resumeRebuild(m.ios, m.opts); job != nil {
+		m.job = job
+		m.replaced <- job
+	}
+}
+
+// rebuild copies the stream from a healthy member to the replaced member one
+// chunk at a time, holding the mirror lock only while copying a chunk. The
+// stream is copied as stored on the members, including any checksums.
+func (m *Mirror) rebuild(job *RebuildJob) {
+	m.Lock()
+	m.job = job
+	dst := m.ios[job.idx]
+	m.Unlock()
+
+	dst.emit(RebuildStarted, nil)
+
+	buf := make([]byte, rebuildChunkSize)
+
+	for {
+		if err := job.ctx.Err(); err != nil {
+			m.finish(job, err)
+			return
+		}
+
+		m.Lock()
+
+		if !job.open {
+			if err := job.start(m); err != nil {
+				m.Unlock()
+				m.finish(job, err)
+				return
+			}
+		}
+
+		n, err := job.srcs[0].readRaw(buf)
+		if err != nil && err != io.EOF {
+			// start over from another member
+			job.srcs[0].SetState(FAILED)
+			job.suspend()
+			n, err = 0, nil
+		}
+
+		if n > 0 {
+			if _, werr := job.dst.writeRaw(buf[:n]); werr != nil {
+				err = werr
+			}
+		}
+
+		job.mu.Lock()
+		job.done += int64(n)
+		job.mu.Unlock()
+
+		if err == nil && job.open && time.Since(job.checkpoint) >= checkpointInterval {
+			job.save()
+		}
+
+		m.Unlock()
+
+		if err == io.EOF {
+			m.finish(job, nil)
+			return
+		}
+
+		if err != nil {
+			m.finish(job, err)
+			return
+		}
+
+		job.throttle()
+	}
+}
+
+// finish ends the rebuild with err.
+func (m *Mirror) finish(job *RebuildJob, err error) {
+	m.Lock()
+
+	job.suspend()
+
+	dst := m.ios[job.idx]
+
+	switch {
+	case err == nil:
+		dst.SetState(OK)
+
+		if m.state.get() == DEGRADED && !anyDegraded(m.ios) {
+			var numFailed int
+			for _, member := range m.ios {
+				if !member.usable() {
+					numFailed++
+				}
+			}
+
+			if numFailed == 0 {
+				m.state.set(OK)
+			}
+		}
+	case dst.State() != FAILED:
+		// the member still awaits a rebuild
+		dst.SetState(REPLACED)
+	}
+
+	job.forget(dst)
+
+	m.job = nil
+
+	m.Unlock()
+
+	m.array.observe()
+	dst.emit(RebuildFinished, err)
+
+	job.mu.Lock()
+	job.err = err
+	done := job.done
+	job.mu.Unlock()
+
+	if err == nil {
+		log.Printf("finished rebuilding, copied %d bytes", done)
+	}
+
+	close(job.finished)
+}
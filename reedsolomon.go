@@ -0,0 +1,205 @@
+package streammux
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	errSingularMatrix = errors.New("matrix is singular")
+	errGeometry       = errors.New("invalid array geometry")
+)
+
+// gfMatrix is a matrix over GF(2^8).
+type gfMatrix [][]byte
+
+func newGFMatrix(rows, cols int) gfMatrix {
+	m := make(gfMatrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+
+	return m
+}
+
+// newCodingMatrix returns the (k+m) x k encoding matrix of a systematic
+// Reed-Solomon code. The top k rows are the identity and the bottom m rows
+// are a Cauchy matrix, which makes every k x k submatrix invertible so that
+// any m members can be lost.
+//
+// The Cauchy matrix is scaled such that the first coding row and the first
+// column are all ones. With this, the first coding member is plain XOR parity
+// and with k = 1 all coding members are copies of the data member.
+//
+// The rows and columns of the Cauchy matrix are labelled with distinct field
+// elements, so k+m must not exceed the 256 elements of GF(2^8).
+func newCodingMatrix(k, m int) (gfMatrix, error) {
+	if k < 1 || m < 0 || k+m > 256 {
+		return nil, fmt.Errorf("%w: %d data and %d coding members", errGeometry, k, m)
+	}
+
+	enc := newGFMatrix(k+m, k)
+
+	for i := 0; i < k; i++ {
+		enc[i][i] = 1
+	}
+
+	// C[i][j] = 1 / (x_i + y_j) with x_i = k+i and y_j = j
+	for i := 0; i < m; i++ {
+		for j := 0; j < k; j++ {
+			enc[k+i][j] = gfInv(byte(k+i) ^ byte(j))
+		}
+	}
+
+	if m == 0 {
+		return enc, nil
+	}
+
+	// scale the columns so the first coding row is all ones
+	for j := 0; j < k; j++ {
+		c := gfInv(enc[k][j])
+		for i := 0; i < m; i++ {
+			enc[k+i][j] = gfMul(enc[k+i][j], c)
+		}
+	}
+
+	// scale the rows so the first column is all ones
+	for i := 1; i < m; i++ {
+		c := gfInv(enc[k+i][0])
+		for j := 0; j < k; j++ {
+			enc[k+i][j] = gfMul(enc[k+i][j], c)
+		}
+	}
+
+	return enc, nil
+}
+
+// invert returns the inverse of the square matrix a using Gauss-Jordan
+// elimination.
+func (a gfMatrix) invert() (gfMatrix, error) {
+	n := len(a)
+
+	// work on an augmented copy [a | I]
+	work := newGFMatrix(n, 2*n)
+	for i := range a {
+		copy(work[i], a[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		// find a pivot
+		pivot := -1
+		for row := col; row < n; row++ {
+			if work[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+
+		if pivot == -1 {
+			return nil, errSingularMatrix
+		}
+
+		work[col], work[pivot] = work[pivot], work[col]
+
+		// normalize the pivot row
+		if c := work[col][col]; c != 1 {
+			gfMulSlice(work[col], work[col], gfInv(c))
+		}
+
+		// eliminate the column from the other rows
+		for row := 0; row < n; row++ {
+			if row == col || work[row][col] == 0 {
+				continue
+			}
+
+			gfMulAddSlice(work[row], work[col], work[row][col])
+		}
+	}
+
+	inv := newGFMatrix(n, n)
+	for i := range inv {
+		copy(inv[i], work[i][n:])
+	}
+
+	return inv, nil
+}
+
+// encode computes the coding chunks from the data chunks using the coding
+// rows of enc.
+func (enc gfMatrix) encode(data, coding StripeBufferList) {
+	k := len(data)
+
+	for i, out := range coding {
+		row := enc[k+i]
+
+		gfMulSlice(out, data[0], row[0])
+		for j := 1; j < k; j++ {
+			gfMulAddSlice(out, data[j], row[j])
+		}
+	}
+}
+
+// reconstruct fills in the missing (nil) data chunks of chunks, which holds
+// k data chunks followed by the coding chunks. At least k chunks must be
+// present.
+func (enc gfMatrix) reconstruct(chunks StripeBufferList) error {
+	k := len(enc[0])
+
+	var size int
+	var missing bool
+
+	for i, buf := range chunks {
+		if buf != nil {
+			size = len(buf)
+		} else if i < k {
+			missing = true
+		}
+	}
+
+	if !missing {
+		return nil
+	}
+
+	// pick the first k available chunks, preferring data chunks
+	rows := make([]int, 0, k)
+	for i, buf := range chunks {
+		if buf == nil {
+			continue
+		}
+
+		rows = append(rows, i)
+		if len(rows) == k {
+			break
+		}
+	}
+
+	if len(rows) < k {
+		return errors.New("too few chunks to reconstruct")
+	}
+
+	sub := newGFMatrix(k, k)
+	for i, row := range rows {
+		copy(sub[i], enc[row])
+	}
+
+	dec, err := sub.invert()
+	if err != nil {
+		return err
+	}
+
+	for j := 0; j < k; j++ {
+		if chunks[j] != nil {
+			continue
+		}
+
+		out := make(StripeBuffer, size)
+		for i, row := range rows {
+			gfMulAddSlice(out, chunks[row], dec[j][i])
+		}
+
+		chunks[j] = out
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package streammux
+
+import (
+	"bytes"
+	"sort"
+)
+
+// scrubChunkSize is the number of bytes read from each member at a time by a
+// scrub of a mirror.
+const scrubChunkSize = 64 << 10
+
+// ScrubReport describes the inconsistencies found by a scrub.
+type ScrubReport struct {
+	// Bytes is the number of bytes verified on each member.
+	Bytes int64
+
+	// Mismatches holds every chunk that was found to be inconsistent.
+	Mismatches []Mismatch
+
+	// Repaired holds the indices of the members that are being rebuilt to
+	// repair the mismatches.
+	Repaired []int
+}
+
+// Mismatch is an inconsistent chunk found by a scrub.
+type Mismatch struct {
+	// Offset is the offset of the chunk in the data stored on the members.
+	Offset int64
+
+	// Member is the index of the member holding the bad chunk, or -1 if it
+	// cannot be determined.
+	Member int
+}
+
+// culprits returns the members held responsible for the mismatches, each
+// once. If any mismatch could not be attributed, no member is returned.
+func (r *ScrubReport) culprits() []int {
+	var idxs []int
+	seen := make(map[int]bool)
+
+	for _, mm := range r.Mismatches {
+		if mm.Member == -1 {
+			return nil
+		}
+
+		if !seen[mm.Member] {
+			seen[mm.Member] = true
+			idxs = append(idxs, mm.Member)
+		}
+	}
+
+	return idxs
+}
+
+// vote returns the indices of the chunks that differ from the chunk held by a
+// strict majority. If there is no majority, ok is false.
+func vote(chunks map[int][]byte) (bad []int, ok bool) {
+	var majority []byte
+	var best int
+
+	for _, a := range chunks {
+		var count int
+		for _, b := range chunks {
+			if bytes.Equal(a, b) {
+				count++
+			}
+		}
+
+		if count > best {
+			best, majority = count, a
+		}
+	}
+
+	if 2*best <= len(chunks) {
+		return nil, false
+	}
+
+	for idx, chunk := range chunks {
+		if !bytes.Equal(chunk, majority) {
+			bad = append(bad, idx)
+		}
+	}
+
+	sort.Ints(bad)
+
+	return bad, true
+}
@@ -0,0 +1,326 @@
+package streammux_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+func TestMirrorScrub(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	m := streammux.NewMirror(blkdevs[0], blkdevs[1], blkdevs[2])
+
+	data := make([]byte, 1<<18)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+	writeArray(t, m, data)
+
+	report, err := m.Scrub(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Bytes != int64(len(data)) || len(report.Mismatches) != 0 {
+		t.Fatalf("expected %d consistent bytes, got %d bytes and %v", len(data), report.Bytes, report.Mismatches)
+	}
+
+	// flip a bit in the second chunk of the last copy
+	corrupt(t, blkdevs[2], streammux.SuperblockSize+70000)
+
+	report, err = m.Scrub(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []streammux.Mismatch{{Offset: 64 << 10, Member: 2}}
+	if len(report.Mismatches) != 1 || report.Mismatches[0] != want[0] {
+		t.Fatalf("expected mismatches %v, got %v", want, report.Mismatches)
+	}
+
+	if len(report.Repaired) != 1 || report.Repaired[0] != 2 {
+		t.Fatalf("expected member 2 to be repaired, got %v", report.Repaired)
+	}
+
+	if report, err = m.Scrub(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Mismatches) != 0 {
+		t.Fatalf("expected the repaired mirror to be consistent, got %v", report.Mismatches)
+	}
+
+	if m.Health() != streammux.OK {
+		t.Fatal("expected the repaired mirror to be OK")
+	}
+
+	// the data must now come from the repaired copy
+	restore(t, blkdevs[0], make([]byte, 1<<20))
+	restore(t, blkdevs[1], make([]byte, 1<<20))
+
+	m.Open()
+	defer m.Close()
+
+	got, err := io.ReadAll(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read from the repaired copy differs from the data written")
+	}
+}
+
+func TestMirrorScrubUndetermined(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	m := streammux.NewMirror(blkdevs[0], blkdevs[1])
+
+	data := make([]byte, 1<<18)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Open()
+	writeArray(t, m, data)
+
+	corrupt(t, blkdevs[1], streammux.SuperblockSize+100)
+
+	report, err := m.Scrub(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two copies without checksums cannot tell which one is bad
+	want := []streammux.Mismatch{{Offset: 0, Member: -1}}
+	if len(report.Mismatches) != 1 || report.Mismatches[0] != want[0] {
+		t.Fatalf("expected mismatches %v, got %v", want, report.Mismatches)
+	}
+
+	if len(report.Repaired) != 0 {
+		t.Fatalf("expected nothing to be repaired, got %v", report.Repaired)
+	}
+}
+
+func TestDedicatedParityScrub(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	dp := newParityArray(blkdevs)
+
+	data := make([]byte, 1<<16)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	writeArray(t, dp, data)
+
+	// flip a bit in the fourth parity chunk
+	corrupt(t, blkdevs[0], streammux.SuperblockSize+3*512+10)
+
+	report, err := dp.Scrub(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []streammux.Mismatch{{Offset: 3 * 512, Member: 3}}
+	if len(report.Mismatches) != 1 || report.Mismatches[0] != want[0] {
+		t.Fatalf("expected mismatches %v, got %v", want, report.Mismatches)
+	}
+
+	if len(report.Repaired) != 1 || report.Repaired[0] != 3 {
+		t.Fatalf("expected the parity member to be repaired, got %v", report.Repaired)
+	}
+
+	if report, err = dp.Scrub(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Mismatches) != 0 {
+		t.Fatalf("expected the repaired array to be consistent, got %v", report.Mismatches)
+	}
+
+	// reconstruct the data from the repaired parity
+	restore(t, blkdevs[1], make([]byte, 1<<20))
+
+	dp.Open()
+	defer dp.Close()
+
+	got, err := io.ReadAll(dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data reconstructed from the repaired parity differs from the data written")
+	}
+}
+
+func TestDedicatedParityScrubChecksums(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	dp := streammux.NewDedicatedParity(blkdevs[0], []io.ReadWriteCloser{blkdevs[1], blkdevs[2]},
+		streammux.WithStripeUnit(512),
+		streammux.WithChecksums(),
+	)
+
+	data := make([]byte, 1<<14)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	writeArray(t, dp, data)
+
+	// flip a bit in the second chunk of the first stripe member
+	corrupt(t, blkdevs[1], streammux.SuperblockSize+(512+8)+20)
+
+	report, err := dp.Scrub(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []streammux.Mismatch{{Offset: 512, Member: 0}}
+	if len(report.Mismatches) != 1 || report.Mismatches[0] != want[0] {
+		t.Fatalf("expected mismatches %v, got %v", want, report.Mismatches)
+	}
+
+	if report, err = dp.Scrub(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Mismatches) != 0 {
+		t.Fatalf("expected the repaired array to be consistent, got %v", report.Mismatches)
+	}
+}
+
+func TestMirrorScrubSpare(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 16),
+	}
+
+	spare := testutil.NewBlockDevice(1 << 20)
+
+	m := streammux.NewMirrorWithOptions([]io.ReadWriteCloser{blkdevs[0], blkdevs[1], blkdevs[2]},
+		streammux.WithSparePool(streammux.NewSparePool([]io.ReadWriteCloser{spare})),
+	)
+
+	data := make([]byte, 1<<18)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the last copy moves on to the spare once its device is full
+	m.Open()
+	writeArray(t, m, data)
+
+	corrupt(t, spare, streammux.SuperblockSize+70000)
+
+	report, err := m.Scrub(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Repaired) != 1 || report.Repaired[0] != 2 {
+		t.Fatalf("expected member 2 to be repaired, got %v", report.Repaired)
+	}
+
+	// the copy is rebuilt onto its device and the spare it moved on to
+	if report, err = m.Scrub(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Mismatches) != 0 {
+		t.Fatalf("expected the repaired mirror to be consistent, got %v", report.Mismatches)
+	}
+
+	restore(t, blkdevs[0], make([]byte, 1<<20))
+	restore(t, blkdevs[1], make([]byte, 1<<20))
+
+	m.Open()
+	defer m.Close()
+
+	if got := readAll(t, m, 4096); !bytes.Equal(data, got) {
+		t.Fatal("data read from the repaired copy differs from the data written")
+	}
+}
+
+func TestDedicatedParityScrubSpare(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 13),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	spare := testutil.NewBlockDevice(1 << 20)
+
+	dp := streammux.NewDedicatedParity(blkdevs[0], []io.ReadWriteCloser{blkdevs[1], blkdevs[2], blkdevs[3]},
+		streammux.WithStripeUnit(512),
+		streammux.WithSparePool(streammux.NewSparePool([]io.ReadWriteCloser{spare})),
+	)
+
+	data := make([]byte, 1<<16)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the parity moves on to the spare once its device is full
+	dp.Open()
+	writeArray(t, dp, data)
+
+	corrupt(t, spare, streammux.SuperblockSize+1000)
+
+	report, err := dp.Scrub(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Repaired) != 1 || report.Repaired[0] != 3 {
+		t.Fatalf("expected the parity member to be repaired, got %v", report.Repaired)
+	}
+
+	if report, err = dp.Scrub(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Mismatches) != 0 {
+		t.Fatalf("expected the repaired array to be consistent, got %v", report.Mismatches)
+	}
+
+	// reconstruct the data from the repaired parity
+	restore(t, blkdevs[1], make([]byte, 1<<20))
+
+	dp.Open()
+	defer dp.Close()
+
+	if got := readAll(t, dp, 4096); !bytes.Equal(data, got) {
+		t.Fatal("data reconstructed from the repaired parity differs from the data written")
+	}
+}
@@ -2,8 +2,8 @@ package streammux
 
 import (
 	"context"
-	"errors"
 	"io"
+	"sync/atomic"
 )
 
 type SparePool struct {
@@ -11,12 +11,16 @@ type SparePool struct {
 	shutdown context.CancelFunc
 	spares   []io.ReadWriteCloser
 	ch       chan io.ReadWriteCloser
+
+	// number of spares not yet handed out
+	available int64
 }
 
 func NewSparePool(rwcs []io.ReadWriteCloser) *SparePool {
 	sp := &SparePool{
-		spares: rwcs,
-		ch:     make(chan io.ReadWriteCloser),
+		spares:    rwcs,
+		ch:        make(chan io.ReadWriteCloser),
+		available: int64(len(rwcs)),
 	}
 
 	sp.ctx, sp.shutdown = context.WithCancel(context.Background())
@@ -49,13 +53,24 @@ type request struct{}
 
 func (sp *SparePool) Get() (spare io.ReadWriteCloser, err error) {
 	if sp == nil {
-		return nil, errors.New("no spares")
+		return nil, ErrNoSpares
 	}
 
 	spare = <-sp.ch
 	if spare == nil {
-		err = errors.New("no spares")
+		err = ErrNoSpares
+	} else {
+		atomic.AddInt64(&sp.available, -1)
 	}
 
 	return
 }
+
+// Available returns the number of spares left in the pool.
+func (sp *SparePool) Available() int {
+	if sp == nil {
+		return 0
+	}
+
+	return int(atomic.LoadInt64(&sp.available))
+}
@@ -1,45 +1,78 @@
 package streammux
 
 import (
+	"errors"
 	"io"
-	"strconv"
 	"sync"
-	"syscall"
 )
 
 type StripeBuffer []byte
 
 type StripeBufferList []StripeBuffer
 
-func split(p StripeBuffer, stripeWidth int) StripeBufferList {
-	if len(p)%wordSize != 0 {
-		panic("buffer must be a multiple of the architecture wordSize (" + strconv.Itoa(wordSize) + ")")
+var (
+	errStripeWidth = errors.New("buffer must be a multiple of the stripe width")
+	errMigrating   = errors.New("a member is already being migrated")
+)
+
+func split(p StripeBuffer, stripeWidth int) (StripeBufferList, error) {
+	if stripeWidth < 1 || len(p)%stripeWidth != 0 {
+		return nil, errStripeWidth
 	}
 
-	stripeSize := len(p) / stripeWidth
 	lst := make(StripeBufferList, stripeWidth)
-	for i := 0; i < stripeWidth; i++ {
+	lst.split(p)
+
+	return lst, nil
+}
+
+// split sets the buffers of lst to consecutive, equally sized parts of p,
+// whose length must be a multiple of the length of lst. It allows a list to
+// be reused from stripe to stripe.
+func (lst StripeBufferList) split(p StripeBuffer) {
+	stripeSize := len(p) / len(lst)
+	for i := range lst {
 		lst[i] = p[i*stripeSize : i*stripeSize+stripeSize]
 	}
+}
 
-	return lst
+// reset clears the buffers of lst such that it holds on to no memory.
+func (lst StripeBufferList) reset() {
+	for i := range lst {
+		lst[i] = nil
+	}
 }
 
+// XOR returns the XOR of all buffers in src. The buffers are assumed to be of
+// equal length.
 func (src StripeBufferList) XOR() StripeBuffer {
-	if len(src) < 2 {
-		panic("cannot xor less than two byte arrays")
+	if len(src) == 0 {
+		return nil
 	}
 
 	dst := make(StripeBuffer, len(src[0]))
+	src.XORInto(dst)
 
-	a := src[0]
+	return dst
+}
+
+// XORInto stores the XOR of all buffers in src in dst without allocating. The
+// buffers are assumed to be of the same length as dst, which may be one of
+// them. Otherwise only as many bytes as the shortest buffer holds are stored.
+// With no buffers in src, dst is zeroed.
+func (src StripeBufferList) XORInto(dst StripeBuffer) {
+	xorSlices(dst, src)
+}
 
-	for i := 1; i < len(src); i++ {
-		xorWords(dst, a, src[i])
-		a = dst
+// missing returns the number of nil buffers in lst.
+func (lst StripeBufferList) missing() (n int) {
+	for _, buf := range lst {
+		if buf == nil {
+			n++
+		}
 	}
 
-	return dst
+	return
 }
 
 type Stripe struct {
@@ -47,14 +80,32 @@ type Stripe struct {
 	sync.Mutex
 
 	ios []*Member
+	buf *striper
+
+	// the array identity shared by the members
+	array *array
 
-	state State
+	// the reads and writes in flight on the members
+	ahead *readahead
+	pipe  *pipeline
+
+	// the member being copied by Migrate, if any
+	migration *migration
+
+	state health
 }
 
 func (s *Stripe) Health() State {
-	return s.state
+	return s.state.get()
+}
+
+// Members returns the members of the behavior.
+func (s *Stripe) Members() []*Member {
+	return s.ios
 }
 
+// NewStripe returns a Stripe of rwcs. Media holding a stripe of the same
+// geometry is adopted when opened and blank media is formatted.
 func NewStripe(rwcs []io.ReadWriteCloser, opts ...MemberOption) *Stripe {
 	stripe := &Stripe{
 		ios: make([]*Member, len(rwcs)),
@@ -64,88 +115,162 @@ func NewStripe(rwcs []io.ReadWriteCloser, opts ...MemberOption) *Stripe {
 		stripe.ios[i] = NewMember(rwc, opts...)
 	}
 
+	o := newMemberOptions(opts)
+	stripe.ahead = newReadahead(o.readAhead, len(rwcs), stripe.issueRead, stripe.completeRead)
+	stripe.buf = newStriper(o.stripeUnit, len(rwcs), stripe.ahead.read, stripe.writeStripe)
+
+	stripe.array = attach(stripe, KindStripe, len(rwcs), o.stripeUnit, stripe.ios)
+	stripe.pipe = newPipeline(o.queueDepth, len(rwcs), stripe.completeWrite)
+
 	return stripe
 }
 
 func (s *Stripe) Open() State {
 	s.Lock()
 
+	// foreground I/O takes priority over a running migration
+	if s.migration != nil {
+		s.migration.suspend()
+	}
+
+	// reset state
+	s.state.set(OK)
+	s.buf.reset()
+
 	for _, rwc := range s.ios {
 		state := rwc.Open()
 		switch state {
 		case FAILED:
 			// we're done for
-			s.state = FAILED
+			s.state.set(FAILED)
 		case DEGRADED:
-			if s.state == FAILED {
+			if s.state.get() == FAILED {
 				break
 			}
 
-			s.state = DEGRADED
+			s.state.set(DEGRADED)
 		}
 	}
 
-	return s.state
+	s.array.observe()
+
+	return s.state.get()
 }
 
 func (s *Stripe) Close() (err error) {
 	defer s.Unlock()
 
+	flushErr := s.buf.flush()
+
+	// wait for the queued writes, including the final stripe
+	if perr := s.pipe.flush(); flushErr == nil {
+		flushErr = perr
+	}
+
+	s.ahead.reset()
+
 	for _, closer := range s.ios {
 		err = closer.Close()
 	}
 
+	if flushErr != nil {
+		err = flushErr
+	}
+
 	return
 }
 
 func (s *Stripe) Read(p []byte) (n int, err error) {
-	if s.state != OK {
-		return 0, syscall.EIO
-	}
+	defer s.array.observe()
+
+	return s.buf.read(p)
+}
+
+func (s *Stripe) Write(p []byte) (n int, err error) {
+	defer s.array.observe()
 
-	ch := make(chan rwT)
+	return s.buf.write(p)
+}
+
+// issueRead submits the reads of a single stripe into r.p.
+func (s *Stripe) issueRead(r *inflight) error {
+	if s.state.get() == FAILED {
+		return ErrArrayFailed
+	}
 
-	stripe := split(p, len(s.ios))
+	stripe, err := split(r.p, len(s.ios))
+	if err != nil {
+		return err
+	}
 
 	for i, reader := range s.ios {
-		go reader.read(i, stripe[i], ch)
+		r.fetch(reader, i, stripe[i])
 	}
 
-	for range s.ios {
-		rc := <-ch
+	return nil
+}
+
+// completeRead collects the results of a read issued by issueRead.
+func (s *Stripe) completeRead(r *inflight) (n int, err error) {
+	for i := 0; i < r.active; i++ {
+		rc := <-r.results
 
 		n += rc.n
-		err = rc.err
 
-		if err != nil && err != io.EOF {
-			s.state = FAILED
+		if rc.err != nil && rc.err != io.EOF {
+			s.state.set(FAILED)
+			err = rc.err
+
+			continue
 		}
+
+		// a single member at EOF ends the stream
+		if rc.err == io.EOF && err == nil {
+			err = io.EOF
+		}
+	}
+
+	if s.state.get() == OK && anyDegraded(s.ios) {
+		s.state.set(DEGRADED)
 	}
 
 	return
 }
 
-func (s *Stripe) Write(p []byte) (n int, err error) {
+// writeStripe writes p as a single stripe.
+func (s *Stripe) writeStripe(p []byte) (n int, err error) {
 	s.seq++
-	if s.state != OK {
-		return 0, syscall.EIO
+	if s.state.get() == FAILED {
+		return 0, ErrArrayFailed
+	}
+
+	if len(p)%len(s.ios) != 0 {
+		return 0, errStripeWidth
 	}
 
-	ch := make(chan rwT)
+	w, p := s.pipe.begin(p)
 
-	stripe := split(p, len(s.ios))
+	stripe, _ := split(p, len(s.ios))
 
 	for i, writer := range s.ios {
-		go writer.write(i, stripe[i], ch)
+		w.submit(writer, i, stripe[i])
 	}
 
-	for range s.ios {
-		rc := <-ch
+	return s.pipe.end(w)
+}
+
+// completeWrite collects the results of a write issued by writeStripe.
+func (s *Stripe) completeWrite(w *inflight) (n int, err error) {
+	var failures MemberErrors
+
+	for i := 0; i < w.active; i++ {
+		rc := <-w.results
 
 		//log.Printf("[s] seq=%d, rc.n=%d, rc.err=%v", s.seq, rc.n, rc.err)
 		if rc.err != nil && rc.err != io.EOF {
-			s.state = FAILED
+			s.state.set(FAILED)
 
+			failures.add(rc.err)
 			err = rc.err
 			continue
 		}
@@ -157,6 +282,152 @@ func (s *Stripe) Write(p []byte) (n int, err error) {
 		n += rc.n
 	}
 
+	if s.state.get() == OK && anyDegraded(s.ios) {
+		s.state.set(DEGRADED)
+	}
+
 	//log.Printf("[s return] seq=%d, n=%d, err=%v", s.seq, n, err)
-	return
+	return n, failures.join(err)
+}
+
+// Migrate copies the member at idx, including any spare segments it has moved
+// on to, onto rwc and replaces the member with it. It is used to retire a
+// device that is still readable, but throwing errors. The copy is made one
+// chunk at a time, holding the stripe lock only while copying a chunk, such
+// that the stripe remains in use. A session on the stripe suspends the copy,
+// which starts over if a new stream is written.
+func (s *Stripe) Migrate(idx int, rwc io.ReadWriteCloser) error {
+	s.Lock()
+
+	if s.migration != nil {
+		s.Unlock()
+		return errMigrating
+	}
+
+	old := s.ios[idx]
+	if !old.usable() {
+		s.Unlock()
+		return &MemberError{Member: idx, Op: OpRead, Err: ErrMemberFailed}
+	}
+
+	// the new member takes the place of the old one in the array
+	member := NewMember(rwc)
+	member.opts = old.opts
+	member.array, member.index = old.array, idx
+
+	mg := &migration{src: old, dst: member}
+	s.migration = mg
+
+	s.Unlock()
+
+	buf := make([]byte, rebuildChunkSize)
+
+	for {
+		s.Lock()
+
+		err := mg.copy(buf)
+		if err == nil {
+			s.Unlock()
+			continue
+		}
+
+		mg.suspend()
+		s.migration = nil
+
+		if err != io.EOF {
+			s.Unlock()
+			return err
+		}
+
+		member.SetState(OK)
+		s.ios[idx] = member
+
+		if s.state.get() == DEGRADED && !anyDegraded(s.ios) {
+			s.state.set(OK)
+		}
+
+		s.Unlock()
+
+		return nil
+	}
+}
+
+// migration is the copy of a member onto a new device made by Migrate.
+type migration struct {
+	src, dst *Member
+
+	// whether the members are open, the generation being copied and the
+	// number of bytes copied
+	open       bool
+	generation uint64
+	done       int64
+}
+
+// copy copies the next chunk of the stream of the old member as stored,
+// including any checksums. It returns io.EOF once the stream is copied. The
+// stripe must be locked.
+func (mg *migration) copy(buf []byte) error {
+	if !mg.open {
+		if err := mg.start(); err != nil {
+			return err
+		}
+	}
+
+	n, err := mg.src.readRaw(buf)
+	if n > 0 {
+		if _, werr := mg.dst.writeRaw(buf[:n]); werr != nil {
+			return werr
+		}
+	}
+
+	mg.done += int64(n)
+
+	return err
+}
+
+// start opens the members and positions both at the offset the copy
+// continues from. The copy starts over if a new stream has been written
+// since. The stripe must be locked.
+func (mg *migration) start() error {
+	// the old member may have failed in a session since
+	if mg.src.Open() == FAILED {
+		return &MemberError{Member: mg.src.index, Op: OpRead, Err: ErrMemberFailed}
+	}
+
+	mg.dst.Open()
+
+	// join the current generation rather than starting a new one
+	mg.dst.SetState(REBUILDING)
+
+	mg.open = true
+
+	if generation := mg.dst.array.currentGeneration(); generation != mg.generation {
+		mg.generation = generation
+		mg.done = 0
+	}
+
+	if mg.done > 0 {
+		if err := mg.dst.skip(mg.done); err != nil {
+			return err
+		}
+
+		if err := mg.src.skip(mg.done); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// suspend closes the members held open by the migration. The stripe must be
+// locked.
+func (mg *migration) suspend() {
+	if !mg.open {
+		return
+	}
+
+	mg.src.Close()
+	mg.dst.Close()
+
+	mg.open = false
 }
@@ -2,10 +2,12 @@ package streammux_test
 
 import (
 	"bytes"
+	"crypto/rand"
 	"crypto/sha256"
 	"io"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/bh107/streammux"
 	"github.com/bh107/streammux/pkg/util/testutil"
@@ -21,7 +23,8 @@ func TestStripe(t *testing.T) {
 
 	s.Open()
 
-	data := make([]byte, 1<<21)
+	// leave room for the superblock and stream trailer
+	data := make([]byte, 1<<21-1<<12)
 
 	f, err := os.Open("/dev/urandom")
 	if err != nil {
@@ -103,7 +106,8 @@ func TestFailingStripeWithSparePool(t *testing.T) {
 
 	s.Open()
 
-	data := make([]byte, 1<<21)
+	// leave room for the superblocks and stream trailer
+	data := make([]byte, 1<<21-1<<12)
 
 	f, err := os.Open("/dev/urandom")
 	if err != nil {
@@ -183,11 +187,12 @@ func TestStripedMirror(t *testing.T) {
 		streammux.NewMirror(blkdevs[2:]...),
 	}
 
-	m := streammux.NewStripe(mirrors)
+	m := streammux.NewStripe(mirrors, streammux.WithStripeUnit(512))
 
 	m.Open()
 
-	data := make([]byte, 1<<21)
+	// leave room for the superblock and stream trailer
+	data := make([]byte, 1<<21-1<<12)
 
 	f, err := os.Open("/dev/urandom")
 	if err != nil {
@@ -266,8 +271,8 @@ func TestMirroredStripe(t *testing.T) {
 	}
 
 	stripes := []*streammux.Stripe{
-		streammux.NewStripe(blkdevs[:2]),
-		streammux.NewStripe(blkdevs[2:]),
+		streammux.NewStripe(blkdevs[:2], streammux.WithStripeUnit(512)),
+		streammux.NewStripe(blkdevs[2:], streammux.WithStripeUnit(512)),
 	}
 
 	m := streammux.NewMirror(
@@ -277,7 +282,8 @@ func TestMirroredStripe(t *testing.T) {
 
 	m.Open()
 
-	data := make([]byte, 1<<21)
+	// leave room for the superblock and stream trailer
+	data := make([]byte, 1<<21-1<<12)
 
 	f, err := os.Open("/dev/urandom")
 	if err != nil {
@@ -295,6 +301,8 @@ func TestMirroredStripe(t *testing.T) {
 
 	p := make([]byte, 1024)
 
+	failures := expectFailures(t, 1)
+
 	for {
 		_, err := buf.Read(p)
 		if err == io.EOF {
@@ -306,11 +314,11 @@ func TestMirroredStripe(t *testing.T) {
 		}
 
 		n, err := m.Write(p)
-		if err != nil || n != len(p) {
-			t.Fatal(err, n)
-		}
+		failures.check(n, len(p), err)
 	}
 
+	failures.done()
+
 	// close to reset position
 	if err := m.Close(); err != nil {
 		t.Fatal(err)
@@ -320,7 +328,7 @@ func TestMirroredStripe(t *testing.T) {
 	m.Replace(1, streammux.NewStripe([]io.ReadWriteCloser{
 		testutil.NewBlockDevice(1 << 20),
 		testutil.NewBlockDevice(1 << 20),
-	},
+	}, streammux.WithStripeUnit(512),
 	))
 
 	// reopen
@@ -350,3 +358,264 @@ func TestMirroredStripe(t *testing.T) {
 		t.Fatal("origSha256Sum != newSha256Sum")
 	}
 }
+
+func TestStripeBufferSizes(t *testing.T) {
+	blkdevs := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	s := streammux.NewStripe(blkdevs)
+
+	s.Open()
+
+	// leave room for the superblock and stream trailer
+	data := make([]byte, 1<<21-1<<12)
+
+	f, err := os.Open("/dev/urandom")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := f.Read(data)
+	if err != nil || n != len(data) {
+		t.Fatal(err)
+	}
+
+	origSha256Sum := sha256.Sum256(data)
+
+	buf := bytes.NewBuffer(data)
+
+	// write with a buffer size unrelated to the stripe unit
+	p := make([]byte, 1000)
+
+	for {
+		n, err := buf.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := s.Write(p[:n])
+		if err != nil || m != n {
+			t.Fatal(err)
+		}
+	}
+
+	// close to reset position
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen
+	s.Open()
+
+	buf.Reset()
+
+	// and read it back with a different one
+	p = make([]byte, 3*(1<<16)+8)
+
+	for {
+		n, err := s.Read(p)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = buf.Write(p[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newSha256Sum := sha256.Sum256(buf.Bytes())
+
+	if origSha256Sum != newSha256Sum {
+		t.Fatal("origSha256Sum != newSha256Sum")
+	}
+}
+
+func TestStripeArbitraryLengths(t *testing.T) {
+	const unit = 64
+
+	for _, length := range []int{0, 1, 7, 3*unit - 1, 3 * unit, 3*unit + 1, 10*3*unit + 5, 12345} {
+		blkdevs := []io.ReadWriteCloser{
+			testutil.NewBlockDevice(1 << 16),
+			testutil.NewBlockDevice(1 << 16),
+			testutil.NewBlockDevice(1 << 16),
+		}
+
+		s := streammux.NewStripe(blkdevs, streammux.WithStripeUnit(unit))
+
+		s.Open()
+
+		data := make([]byte, length)
+
+		f, err := os.Open("/dev/urandom")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.ReadFull(f, data); err != nil {
+			t.Fatal(err)
+		}
+
+		f.Close()
+
+		// write with an odd buffer size, the final write being short
+		for p := data; len(p) > 0; {
+			k := 13
+			if k > len(p) {
+				k = len(p)
+			}
+
+			n, err := s.Write(p[:k])
+			if err != nil || n != k {
+				t.Fatal(err, n)
+			}
+
+			p = p[k:]
+		}
+
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		s.Open()
+
+		got, err := io.ReadAll(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, got) {
+			t.Fatalf("length %d: read back %d bytes that differ from the bytes written", length, len(got))
+		}
+
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStripeMigrate(t *testing.T) {
+	blkdevs := []io.ReadWriteCloser{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewFaultyDevice(1<<20, 20),
+	}
+
+	spares := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	sparePool := streammux.NewSparePool([]io.ReadWriteCloser{spares[0], spares[1]})
+
+	s := streammux.NewStripe(blkdevs,
+		streammux.WithSparePool(sparePool),
+		streammux.WithStripeUnit(512),
+	)
+
+	data := make([]byte, 1<<19)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// the faulty member moves on to a spare while writing
+	s.Open()
+	writeArray(t, s, data)
+
+	if err := s.Migrate(1, testutil.NewBlockDevice(1<<20)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the migrated member must no longer depend on the spares
+	for _, spare := range spares {
+		restore(t, spare, make([]byte, 1<<20))
+	}
+
+	s.Open()
+	defer s.Close()
+
+	got, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("data read after migrating differs from the data written")
+	}
+}
+
+func TestStripeMigrateOnline(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	s := streammux.NewStripe([]io.ReadWriteCloser{blkdevs[0], blkdevs[1]}, streammux.WithStripeUnit(512))
+
+	s.Open()
+	writeArray(t, s, make([]byte, 1<<19))
+
+	// every chunk copied takes a while, such that the stripe is used while
+	// the member is being copied
+	dst := &slowFaultyDevice{
+		BlockDevice: testutil.NewBlockDevice(1 << 20),
+		delay:       20 * time.Millisecond,
+		failAfter:   1 << 30,
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- s.Migrate(1, dst)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	// a new stream written while copying starts the copy over
+	data := make([]byte, 1<<19)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Open()
+	writeArray(t, s, data)
+
+	select {
+	case <-done:
+		t.Fatal("expected the stripe to be usable while migrating")
+	default:
+	}
+
+	for i := 0; i < 3; i++ {
+		s.Open()
+
+		if got := readAll(t, s, 4096); !bytes.Equal(data, got) {
+			t.Fatal("data read while migrating differs from the data written")
+		}
+
+		s.Close()
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the stream is read from the new device only
+	restore(t, blkdevs[1], make([]byte, 1<<20))
+
+	s.Open()
+	defer s.Close()
+
+	if got := readAll(t, s, 4096); !bytes.Equal(data, got) {
+		t.Fatal("data read after migrating differs from the data written")
+	}
+}
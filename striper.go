@@ -0,0 +1,305 @@
+package streammux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// DefaultStripeUnit is the default number of bytes written to each member per
+// stripe.
+const DefaultStripeUnit = 64 << 10
+
+// trailerSize is the size of the stream trailer holding the logical length.
+const trailerSize = 8
+
+var errBadTrailer = errors.New("missing or invalid stream trailer")
+
+// striper buffers reads and writes such that the layout on the members only
+// depends on the stripe unit and not on the buffer sizes used by the caller.
+// Full stripes are handed to the behavior through readStripe and writeStripe.
+//
+// When a stream is closed, the data is zero padded to a multiple of the
+// stripe width and followed by a trailer holding the logical length of the
+// stream. The final stripe may be short, in which case every member holds an
+// equally sized part of it. Reads hold back enough data to strip the padding
+// and trailer, such that exactly the bytes written are returned.
+type striper struct {
+	unit  int
+	width int
+
+	// pending data that does not yet fill a stripe
+	wbuf    []byte
+	writing bool
+	written uint64
+
+	// data read from the members, but not yet returned to the caller
+	rbuf  []byte
+	roff  int
+	total uint64
+	eof   bool
+
+	readStripe  func(p []byte) (n int, err error)
+	writeStripe func(p []byte) (n int, err error)
+
+	// the context of the read or write in progress, if any
+	ctx context.Context
+}
+
+func newStriper(unit, width int, readStripe, writeStripe func(p []byte) (int, error)) *striper {
+	return &striper{
+		unit:        unit,
+		width:       width,
+		readStripe:  readStripe,
+		writeStripe: writeStripe,
+	}
+}
+
+// size returns the number of data bytes in a full stripe.
+func (s *striper) size() int {
+	return s.unit * s.width
+}
+
+// holdback returns the maximum size of the padding and trailer at the end of
+// a stream.
+func (s *striper) holdback() int {
+	return s.width - 1 + trailerSize
+}
+
+// reset prepares the striper for a new stream.
+func (s *striper) reset() {
+	s.wbuf = s.wbuf[:0]
+	s.writing = false
+	s.written = 0
+
+	s.rbuf = s.rbuf[:0]
+	s.roff = 0
+	s.total = 0
+	s.eof = false
+}
+
+// cancelled returns the error of the context of the operation in progress
+// once it is done. No more stripes are started after that.
+func (s *striper) cancelled() error {
+	if s.ctx == nil {
+		return nil
+	}
+
+	return s.ctx.Err()
+}
+
+// write writes p, handing full stripes to the behavior. The member failures
+// of stripes the behavior wrote regardless are returned together once all of
+// p is written.
+func (s *striper) write(p []byte) (n int, err error) {
+	s.writing = true
+	size := s.size()
+
+	var failures MemberErrors
+
+	for len(p) > 0 {
+		if err := s.cancelled(); err != nil {
+			return n, err
+		}
+
+		// write full stripes directly from p if nothing is pending
+		if len(s.wbuf) == 0 && len(p) >= size {
+			if k, err := s.writeStripe(p[:size]); err != nil {
+				if k < size {
+					return n, err
+				}
+
+				failures.add(err)
+			}
+
+			n += size
+			s.written += uint64(size)
+			p = p[size:]
+
+			continue
+		}
+
+		if s.wbuf == nil {
+			s.wbuf = make([]byte, 0, size)
+		}
+
+		k := copy(s.wbuf[len(s.wbuf):size], p)
+		s.wbuf = s.wbuf[:len(s.wbuf)+k]
+
+		n += k
+		s.written += uint64(k)
+		p = p[k:]
+
+		if len(s.wbuf) == size {
+			if k, err := s.writeStripe(s.wbuf); err != nil {
+				if k < size {
+					return n, err
+				}
+
+				failures.add(err)
+			}
+
+			s.wbuf = s.wbuf[:0]
+		}
+	}
+
+	return n, failures.survived()
+}
+
+// flush writes any pending data followed by the padding and the trailer.
+func (s *striper) flush() error {
+	if !s.writing {
+		return nil
+	}
+
+	s.writing = false
+
+	pad := (s.width - (len(s.wbuf)+trailerSize)%s.width) % s.width
+
+	tail := append(s.wbuf, make([]byte, pad+trailerSize)...)
+	binary.LittleEndian.PutUint64(tail[len(tail)-trailerSize:], s.written)
+
+	s.wbuf = s.wbuf[:0]
+
+	var failures MemberErrors
+
+	for size := s.size(); len(tail) > 0; {
+		if size > len(tail) {
+			size = len(tail)
+		}
+
+		if k, err := s.writeStripe(tail[:size]); err != nil {
+			if k < size {
+				return err
+			}
+
+			failures.add(err)
+		}
+
+		tail = tail[size:]
+	}
+
+	return failures.survived()
+}
+
+// available returns the number of buffered bytes that are known to be data
+// and not padding or trailer.
+func (s *striper) available() int {
+	buffered := len(s.rbuf) - s.roff
+
+	if s.eof {
+		return buffered
+	}
+
+	if buffered <= s.holdback() {
+		return 0
+	}
+
+	return buffered - s.holdback()
+}
+
+// fill reads the next stripe into the read buffer. A short stripe ends the
+// stream and is compacted such that the data is contiguous.
+func (s *striper) fill() error {
+	size := s.size()
+
+	if cap(s.rbuf) < size+s.holdback() {
+		rbuf := make([]byte, len(s.rbuf), size+s.holdback())
+		copy(rbuf, s.rbuf)
+		s.rbuf = rbuf
+	}
+
+	// move the bytes held back to the front
+	k := copy(s.rbuf[:cap(s.rbuf)], s.rbuf[s.roff:])
+	s.rbuf = s.rbuf[:k]
+	s.roff = 0
+
+	p := s.rbuf[k : k+size]
+
+	n, err := s.readStripe(p)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if n < size {
+		chunk := n / s.width
+		for i := 1; i < s.width; i++ {
+			copy(p[i*chunk:], p[i*s.unit:i*s.unit+chunk])
+		}
+
+		n = chunk * s.width
+	}
+
+	s.rbuf = s.rbuf[:k+n]
+	s.total += uint64(n)
+
+	if err == io.EOF || n < size {
+		return s.finish()
+	}
+
+	return nil
+}
+
+// finish strips the padding and trailer at the end of the stream.
+func (s *striper) finish() error {
+	s.eof = true
+
+	// nothing was ever written
+	if s.total == 0 {
+		return nil
+	}
+
+	if len(s.rbuf) < trailerSize || s.total < trailerSize {
+		return errBadTrailer
+	}
+
+	length := binary.LittleEndian.Uint64(s.rbuf[len(s.rbuf)-trailerSize:])
+	if length > s.total-trailerSize {
+		return errBadTrailer
+	}
+
+	pad := s.total - trailerSize - length
+	if pad >= uint64(s.width) {
+		return errBadTrailer
+	}
+
+	s.rbuf = s.rbuf[:len(s.rbuf)-trailerSize-int(pad)]
+
+	return nil
+}
+
+func (s *striper) read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if avail := s.available(); avail > 0 {
+			k := copy(p[n:], s.rbuf[s.roff:s.roff+avail])
+			s.roff += k
+			n += k
+
+			continue
+		}
+
+		if s.eof {
+			break
+		}
+
+		// return what has been read when the context is done
+		if err := s.cancelled(); err != nil {
+			if n > 0 {
+				break
+			}
+
+			return 0, err
+		}
+
+		if err := s.fill(); err != nil {
+			return n, err
+		}
+	}
+
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+
+	return n, nil
+}
@@ -0,0 +1,418 @@
+package streammux
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// SuperblockSize is the size of the header written at the start of every
+// member device.
+const SuperblockSize = 64
+
+var superblockMagic = [8]byte{'S', 'M', 'U', 'X', 'S', 'B', '0', '1'}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	errBadSuperblock      = errors.New("invalid member superblock")
+	errSuperblockMismatch = errors.New("member superblock does not match the array")
+)
+
+// Kind identifies the behavior an array was created with.
+type Kind uint8
+
+const (
+	KindStripe Kind = iota + 1
+	KindMirror
+	KindDedicatedParity
+	KindDistributedParity
+	KindDualParity
+	KindErasureCoded
+	KindConcat
+)
+
+// superblock flags
+const (
+	// data is framed in checksummed records
+	flagChecksums = 1 << iota
+)
+
+// UUID uniquely identifies an array.
+type UUID [16]byte
+
+func newUUID() (u UUID) {
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(err)
+	}
+
+	// version 4, variant 1
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+
+	return
+}
+
+func (u UUID) String() string {
+	var buf [36]byte
+
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+
+	return string(buf[:])
+}
+
+// array holds the identity and geometry shared by the members of an array.
+type array struct {
+	sync.Mutex
+
+	uuid    UUID
+	kind    Kind
+	members int
+	data    int
+	unit    int
+	flags   uint8
+
+	// generation of the last stream written and whether a new generation is
+	// currently being written
+	generation uint64
+	writing    bool
+
+	// whether the identity was adopted from the superblock of a member
+	adopted bool
+
+	// the behavior using the members, where its events are published and
+	// its health when last observed
+	behavior Behavior
+	events   *EventBus
+	health   State
+}
+
+// attach assigns a new array identity to the members of behavior b.
+func attach(b Behavior, kind Kind, data, unit int, members []*Member) *array {
+	a := &array{
+		uuid:     newUUID(),
+		kind:     kind,
+		members:  len(members),
+		data:     data,
+		unit:     unit,
+		behavior: b,
+	}
+
+	if len(members) > 0 && members[0].opts.checksums {
+		a.flags |= flagChecksums
+	}
+
+	if len(members) > 0 {
+		a.events = members[0].opts.events
+	}
+
+	for i, m := range members {
+		m.array = a
+		m.index = i
+	}
+
+	return a
+}
+
+// adopt takes the identity of the array from sb, unless it has been adopted
+// already. The newest generation of the array found is the current one.
+func (a *array) adopt(sb *superblock) {
+	a.Lock()
+	defer a.Unlock()
+
+	if !a.adopted {
+		a.adopted = true
+		a.uuid = sb.uuid
+		a.generation = sb.generation
+
+		// a generation started on blank members does not hold a stream
+		a.writing = false
+
+		return
+	}
+
+	if sb.uuid == a.uuid && sb.generation > a.generation && !a.writing {
+		a.generation = sb.generation
+	}
+}
+
+// beginWrite returns the generation of the stream being written. The first
+// member to write in a session bumps the generation.
+func (a *array) beginWrite() uint64 {
+	a.Lock()
+	defer a.Unlock()
+
+	if !a.writing {
+		a.writing = true
+		a.generation++
+	}
+
+	return a.generation
+}
+
+// endWrite ends the current write session.
+func (a *array) endWrite() {
+	a.Lock()
+	defer a.Unlock()
+
+	a.writing = false
+}
+
+func (a *array) currentGeneration() uint64 {
+	a.Lock()
+	defer a.Unlock()
+
+	return a.generation
+}
+
+// superblock is the header written at the start of every member device (and
+// every spare segment of a member).
+type superblock struct {
+	uuid       UUID
+	kind       Kind
+	flags      uint8
+	index      int
+	segment    int
+	members    int
+	data       int
+	unit       int
+	generation uint64
+}
+
+func (sb *superblock) marshal() []byte {
+	buf := make([]byte, SuperblockSize)
+
+	copy(buf[0:8], superblockMagic[:])
+	copy(buf[8:24], sb.uuid[:])
+	buf[24] = byte(sb.kind)
+	buf[25] = sb.flags
+	binary.LittleEndian.PutUint16(buf[26:], uint16(sb.index))
+	binary.LittleEndian.PutUint16(buf[28:], uint16(sb.segment))
+	binary.LittleEndian.PutUint16(buf[30:], uint16(sb.members))
+	binary.LittleEndian.PutUint16(buf[32:], uint16(sb.data))
+	binary.LittleEndian.PutUint32(buf[36:], uint32(sb.unit))
+	binary.LittleEndian.PutUint64(buf[40:], sb.generation)
+
+	binary.LittleEndian.PutUint32(buf[60:], crc32.Checksum(buf[:60], castagnoli))
+
+	return buf
+}
+
+func (sb *superblock) unmarshal(buf []byte) error {
+	if len(buf) < SuperblockSize || !bytes.Equal(buf[0:8], superblockMagic[:]) {
+		return errBadSuperblock
+	}
+
+	if binary.LittleEndian.Uint32(buf[60:]) != crc32.Checksum(buf[:60], castagnoli) {
+		return errBadSuperblock
+	}
+
+	copy(sb.uuid[:], buf[8:24])
+	sb.kind = Kind(buf[24])
+	sb.flags = buf[25]
+	sb.index = int(binary.LittleEndian.Uint16(buf[26:]))
+	sb.segment = int(binary.LittleEndian.Uint16(buf[28:]))
+	sb.members = int(binary.LittleEndian.Uint16(buf[30:]))
+	sb.data = int(binary.LittleEndian.Uint16(buf[32:]))
+	sb.unit = int(binary.LittleEndian.Uint32(buf[36:]))
+	sb.generation = binary.LittleEndian.Uint64(buf[40:])
+
+	return nil
+}
+
+// readSuperblock reads a superblock from r. A device that ends before a full
+// superblock holds no data and io.EOF is returned.
+func readSuperblock(r io.Reader) (*superblock, error) {
+	buf := make([]byte, SuperblockSize)
+
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+
+		return nil, err
+	}
+
+	sb := &superblock{}
+	if err := sb.unmarshal(buf); err != nil {
+		return nil, err
+	}
+
+	return sb, nil
+}
+
+// openSuperblock handles the superblock of a member being opened. An
+// existing superblock is verified against the geometry of the array, whose
+// identity is adopted from the first one found, and is kept until the first
+// read or write. Blank media is given a superblock right away, which joins
+// the stream already written to the array, if any. A superblock that cannot
+// be written is left to the first write, which may move on to a spare.
+func (m *Member) openSuperblock() error {
+	if m.array == nil {
+		return nil
+	}
+
+	sb, err := readSuperblock(m.device())
+	if err == io.EOF {
+		// an array already written is joined in its current generation
+		generation := m.array.currentGeneration()
+		if generation == 0 {
+			generation = m.array.beginWrite()
+		}
+
+		m.putSuperblock(generation)
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	m.array.adopt(sb)
+
+	if !m.matches(sb) {
+		return errSuperblockMismatch
+	}
+
+	m.sbDone = true
+	m.sbFound = sb
+
+	return nil
+}
+
+// matches reports whether sb describes the current segment of the member,
+// regardless of the generation it was written in.
+func (m *Member) matches(sb *superblock) bool {
+	return sb.uuid == m.array.uuid && sb.kind == m.array.kind && sb.flags == m.array.flags &&
+		sb.index == m.index && sb.segment == m.currentSegment &&
+		sb.members == m.array.members && sb.data == m.array.data && sb.unit == m.array.unit
+}
+
+// seekStart moves the current device of the member back to its start. It
+// returns false if the device can neither seek nor be reopened.
+func (m *Member) seekStart() (bool, error) {
+	switch dev := m.rwc.(type) {
+	case io.Seeker:
+		_, err := dev.Seek(0, io.SeekStart)
+		return true, err
+	case Opener:
+		if err := m.rwc.Close(); err != nil {
+			return true, err
+		}
+
+		dev.Open()
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// writeSuperblock writes the superblock to the current segment of the member
+// if it has not been written yet. The superblock found when the member was
+// opened is replaced by the first write, unless the device cannot move back
+// to it.
+func (m *Member) writeSuperblock() error {
+	if m.array == nil {
+		return nil
+	}
+
+	if m.sbFound != nil {
+		m.sbFound = nil
+
+		ok, err := m.seekStart()
+		if err != nil {
+			return err
+		}
+
+		m.sbDone = !ok
+	}
+
+	if m.sbDone {
+		return nil
+	}
+
+	// a member being rebuilt joins the current generation rather than
+	// starting a new one
+	if m.State() == REBUILDING {
+		return m.putSuperblock(m.array.currentGeneration())
+	}
+
+	return m.putSuperblock(m.array.beginWrite())
+}
+
+// putSuperblock writes the superblock of generation to the current segment of
+// the member.
+func (m *Member) putSuperblock(generation uint64) error {
+	sb := &superblock{
+		uuid:       m.array.uuid,
+		kind:       m.array.kind,
+		flags:      m.array.flags,
+		index:      m.index,
+		segment:    m.currentSegment,
+		members:    m.array.members,
+		data:       m.array.data,
+		unit:       m.array.unit,
+		generation: generation,
+	}
+
+	if _, err := m.device().Write(sb.marshal()); err != nil {
+		return err
+	}
+
+	m.sbDone = true
+	m.sbWritten = true
+
+	return nil
+}
+
+// verifySuperblock reads and verifies the superblock of the current segment
+// of the member if it has not been read yet. The superblock found when the
+// member was opened is verified to hold the current generation, which is not
+// known until all members have been opened.
+func (m *Member) verifySuperblock() error {
+	if m.array == nil {
+		return nil
+	}
+
+	if sb := m.sbFound; sb != nil {
+		m.sbFound = nil
+
+		if sb.generation != m.array.currentGeneration() {
+			return errSuperblockMismatch
+		}
+
+		return nil
+	}
+
+	if m.sbDone {
+		return nil
+	}
+
+	sb, err := readSuperblock(m.device())
+	if err != nil {
+		return err
+	}
+
+	if !m.matches(sb) || sb.generation != m.array.currentGeneration() {
+		return errSuperblockMismatch
+	}
+
+	m.sbDone = true
+
+	return nil
+}
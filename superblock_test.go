@@ -0,0 +1,243 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/bh107/streammux"
+	"github.com/bh107/streammux/pkg/util/testutil"
+)
+
+// snapshot returns the contents of a closed block device and rewinds it.
+func snapshot(t *testing.T, blkdev *testutil.BlockDevice) []byte {
+	buf, err := io.ReadAll(blkdev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blkdev.Seek(0, io.SeekStart)
+
+	return buf
+}
+
+// restore overwrites a block device with buf and closes it.
+func restore(t *testing.T, blkdev *testutil.BlockDevice, buf []byte) {
+	blkdev.Seek(0, io.SeekStart)
+
+	if _, err := blkdev.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	blkdev.Close()
+}
+
+func newParityArray(blkdevs []*testutil.BlockDevice) *streammux.DedicatedParity {
+	rwcs := make([]io.ReadWriteCloser, len(blkdevs))
+	for i, blkdev := range blkdevs {
+		rwcs[i] = blkdev
+	}
+
+	return streammux.NewDedicatedParity(rwcs[0], rwcs[1:], streammux.WithStripeUnit(512))
+}
+
+func writeArray(t testing.TB, w io.WriteCloser, data []byte) {
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForeignMember(t *testing.T) {
+	blkdevs := make([]*testutil.BlockDevice, 5)
+	foreign := make([]*testutil.BlockDevice, 5)
+
+	for i := range blkdevs {
+		blkdevs[i] = testutil.NewBlockDevice(1 << 20)
+		foreign[i] = testutil.NewBlockDevice(1 << 20)
+	}
+
+	data := make([]byte, 1<<19)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp := newParityArray(blkdevs)
+	dp.Open()
+	writeArray(t, dp, data)
+
+	other := newParityArray(foreign)
+	other.Open()
+	writeArray(t, other, make([]byte, 1<<19))
+
+	// swap in a member of another array
+	restore(t, blkdevs[2], snapshot(t, foreign[2]))
+
+	dp.Open()
+
+	got, err := io.ReadAll(dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("foreign member was not reconstructed")
+	}
+
+	if dp.Health() != streammux.DEGRADED {
+		t.Fatal("expected the foreign member to degrade the array")
+	}
+
+	dp.Close()
+}
+
+func TestStaleMember(t *testing.T) {
+	blkdevs := make([]*testutil.BlockDevice, 5)
+
+	for i := range blkdevs {
+		blkdevs[i] = testutil.NewBlockDevice(1 << 20)
+	}
+
+	dp := newParityArray(blkdevs)
+
+	dp.Open()
+	writeArray(t, dp, make([]byte, 1<<19))
+
+	stale := snapshot(t, blkdevs[3])
+
+	data := make([]byte, 1<<19)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	writeArray(t, dp, data)
+
+	// put back a member from the previous generation
+	restore(t, blkdevs[3], stale)
+
+	dp.Open()
+
+	got, err := io.ReadAll(dp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Fatal("stale member was not reconstructed")
+	}
+
+	if dp.Health() != streammux.DEGRADED {
+		t.Fatal("expected the stale member to degrade the array")
+	}
+
+	dp.Close()
+}
+
+func TestForeignStripeMember(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	foreign := testutil.NewBlockDevice(1 << 20)
+
+	s := streammux.NewStripe([]io.ReadWriteCloser{blkdevs[0], blkdevs[1]})
+	s.Open()
+	writeArray(t, s, make([]byte, 1<<19))
+
+	m := streammux.NewMirror(foreign)
+	m.Open()
+	writeArray(t, m, make([]byte, 1<<19))
+
+	restore(t, blkdevs[1], snapshot(t, foreign))
+
+	s.Open()
+	defer s.Close()
+
+	if _, err := io.ReadAll(s); err == nil {
+		t.Fatal("expected reading a stripe with a foreign member to fail")
+	}
+
+	if s.Health() != streammux.FAILED {
+		t.Fatal("expected the stripe to be FAILED")
+	}
+}
+
+func TestReopenMembers(t *testing.T) {
+	blkdevs := make([]*testutil.BlockDevice, 5)
+
+	for i := range blkdevs {
+		blkdevs[i] = testutil.NewBlockDevice(1 << 20)
+	}
+
+	dp := newParityArray(blkdevs)
+
+	dp.Open()
+	writeArray(t, dp, make([]byte, 1<<19))
+
+	stale := snapshot(t, blkdevs[1])
+
+	data := make([]byte, 1<<19)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	dp.Open()
+	writeArray(t, dp, data)
+
+	// a new array over the same media adopts the identity of the array
+	// written to it
+	dp = newParityArray(blkdevs)
+
+	if dp.Open() != streammux.OK {
+		t.Fatal("expected the members to be verified when opened")
+	}
+
+	if got := readAll(t, dp, 4096); !bytes.Equal(data, got) {
+		t.Fatal("data read differs from the data written")
+	}
+
+	dp.Close()
+
+	// the first member opened is from the previous generation, which is
+	// found out once all members have been opened
+	restore(t, blkdevs[1], stale)
+
+	dp = newParityArray(blkdevs)
+	dp.Open()
+
+	if got := readAll(t, dp, 4096); !bytes.Equal(data, got) {
+		t.Fatal("stale member was not reconstructed")
+	}
+
+	if dp.Health() != streammux.DEGRADED {
+		t.Fatal("expected the stale member to degrade the array")
+	}
+
+	dp.Close()
+}
+
+func TestReopenForeignGeometry(t *testing.T) {
+	blkdevs := []*testutil.BlockDevice{
+		testutil.NewBlockDevice(1 << 20),
+		testutil.NewBlockDevice(1 << 20),
+	}
+
+	s := streammux.NewStripe([]io.ReadWriteCloser{blkdevs[0], blkdevs[1]})
+	s.Open()
+	writeArray(t, s, make([]byte, 1<<19))
+
+	// the members are verified against the geometry of the new array
+	m := streammux.NewMirror(blkdevs[0], blkdevs[1])
+
+	if m.Open() != streammux.FAILED {
+		t.Fatal("expected members of another kind of array to fail when opened")
+	}
+
+	m.Close()
+}
@@ -6,8 +6,9 @@ import (
 
 const wordSize = int(unsafe.Sizeof(uintptr(0)))
 
-// XORWords XORs multiples of 4 or 8 bytes (depending on architecture.)
-// The arguments are assumed to be of equal length.
+// XORWords XORs multiples of 4 or 8 bytes (depending on architecture) and any
+// remaining tail bytes one at a time. The arguments are assumed to be of
+// equal length.
 //
 // Cribbed from crypto/cipher/xor.go
 func xorWords(dst, a, b []byte) {
@@ -20,4 +21,57 @@ func xorWords(dst, a, b []byte) {
 	for i := 0; i < n; i++ {
 		dw[i] = aw[i] ^ bw[i]
 	}
+
+	for i := n * wordSize; i < len(b); i++ {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// xorSlices sets dst to the XOR of the buffers in srcs. All sources are
+// folded in a single pass over memory. Only as many bytes as the shortest
+// buffer holds are set. The bulk of the buffers is handled by the assembly
+// kernel of the architecture, if there is one, and the rest by xorGeneric.
+// The XOR of no buffers is zero.
+func xorSlices(dst StripeBuffer, srcs StripeBufferList) {
+	if len(srcs) == 0 {
+		for i := range dst {
+			dst[i] = 0
+		}
+
+		return
+	}
+
+	for _, src := range srcs {
+		if len(src) < len(dst) {
+			dst = dst[:len(src)]
+		}
+	}
+
+	xorGeneric(dst, srcs, xorBlocks(dst, srcs))
+}
+
+// xorGeneric sets dst[off:] to the XOR of the buffers in srcs from off
+// onwards a word at a time, where off is a multiple of the word size.
+func xorGeneric(dst StripeBuffer, srcs StripeBufferList, off int) {
+	dw := *(*[]uintptr)(unsafe.Pointer(&dst))
+
+	n := len(dst) / wordSize
+
+	for i := off / wordSize; i < n; i++ {
+		v := (*(*[]uintptr)(unsafe.Pointer(&srcs[0])))[i]
+		for j := 1; j < len(srcs); j++ {
+			v ^= (*(*[]uintptr)(unsafe.Pointer(&srcs[j])))[i]
+		}
+
+		dw[i] = v
+	}
+
+	for i := n * wordSize; i < len(dst); i++ {
+		v := srcs[0][i]
+		for j := 1; j < len(srcs); j++ {
+			v ^= srcs[j][i]
+		}
+
+		dst[i] = v
+	}
 }
@@ -0,0 +1,70 @@
+//go:build !purego
+
+package streammux
+
+// useAVX2 selects the AVX2 kernel over the SSE2 kernel, which every amd64
+// processor supports.
+var useAVX2 = hasAVX2()
+
+// hasAVX2 reports whether the processor supports AVX2 and the operating
+// system saves the YMM registers.
+func hasAVX2() bool {
+	const (
+		osxsave = 1 << 27
+		avx     = 1 << 28
+		avx2    = 1 << 5
+	)
+
+	if maxID, _, _, _ := cpuid(0, 0); maxID < 7 {
+		return false
+	}
+
+	if _, _, ecx, _ := cpuid(1, 0); ecx&osxsave == 0 || ecx&avx == 0 {
+		return false
+	}
+
+	// the XMM and YMM state must be enabled
+	if eax, _ := xgetbv(); eax&6 != 6 {
+		return false
+	}
+
+	_, ebx, _, _ := cpuid(7, 0)
+
+	return ebx&avx2 != 0
+}
+
+//go:noescape
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+//go:noescape
+func xgetbv() (eax, edx uint32)
+
+// xorAVX2 and xorSSE2 set the first n bytes of dst to the XOR of the first n
+// bytes of the nsrcs buffers at srcs. n must be a multiple of 128 and 64
+// respectively.
+//
+//go:noescape
+func xorAVX2(dst *byte, srcs *StripeBuffer, nsrcs, n int)
+
+//go:noescape
+func xorSSE2(dst *byte, srcs *StripeBuffer, nsrcs, n int)
+
+// xorBlocks XORs the srcs into dst in blocks of 128 or 64 bytes and returns
+// the number of bytes handled.
+func xorBlocks(dst StripeBuffer, srcs StripeBufferList) int {
+	if useAVX2 {
+		n := len(dst) &^ 127
+		if n > 0 {
+			xorAVX2(&dst[0], &srcs[0], len(srcs), n)
+		}
+
+		return n
+	}
+
+	n := len(dst) &^ 63
+	if n > 0 {
+		xorSSE2(&dst[0], &srcs[0], len(srcs), n)
+	}
+
+	return n
+}
@@ -0,0 +1,126 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
+
+// Both kernels keep a block of the first source in registers, fold the same
+// block of every other source into it and store the result, such that memory
+// is walked only once. The sources are slice headers of 24 bytes.
+
+// func xorAVX2(dst *byte, srcs *StripeBuffer, nsrcs, n int)
+TEXT ·xorAVX2(SB), NOSPLIT, $0-32
+	MOVQ dst+0(FP), DI
+	MOVQ srcs+8(FP), SI
+	MOVQ nsrcs+16(FP), CX
+	MOVQ n+24(FP), DX
+	XORQ AX, AX
+
+avx2Block:
+	CMPQ AX, DX
+	JAE  avx2Done
+
+	MOVQ    (SI), R8
+	VMOVDQU (R8)(AX*1), Y0
+	VMOVDQU 32(R8)(AX*1), Y1
+	VMOVDQU 64(R8)(AX*1), Y2
+	VMOVDQU 96(R8)(AX*1), Y3
+
+	MOVQ $1, BX
+	LEAQ 24(SI), R9
+
+avx2Source:
+	CMPQ BX, CX
+	JAE  avx2Store
+
+	MOVQ  (R9), R8
+	VPXOR (R8)(AX*1), Y0, Y0
+	VPXOR 32(R8)(AX*1), Y1, Y1
+	VPXOR 64(R8)(AX*1), Y2, Y2
+	VPXOR 96(R8)(AX*1), Y3, Y3
+
+	ADDQ $24, R9
+	INCQ BX
+	JMP  avx2Source
+
+avx2Store:
+	VMOVDQU Y0, (DI)(AX*1)
+	VMOVDQU Y1, 32(DI)(AX*1)
+	VMOVDQU Y2, 64(DI)(AX*1)
+	VMOVDQU Y3, 96(DI)(AX*1)
+
+	ADDQ $128, AX
+	JMP  avx2Block
+
+avx2Done:
+	VZEROUPPER
+	RET
+
+// func xorSSE2(dst *byte, srcs *StripeBuffer, nsrcs, n int)
+TEXT ·xorSSE2(SB), NOSPLIT, $0-32
+	MOVQ dst+0(FP), DI
+	MOVQ srcs+8(FP), SI
+	MOVQ nsrcs+16(FP), CX
+	MOVQ n+24(FP), DX
+	XORQ AX, AX
+
+sse2Block:
+	CMPQ AX, DX
+	JAE  sse2Done
+
+	MOVQ  (SI), R8
+	MOVOU (R8)(AX*1), X0
+	MOVOU 16(R8)(AX*1), X1
+	MOVOU 32(R8)(AX*1), X2
+	MOVOU 48(R8)(AX*1), X3
+
+	MOVQ $1, BX
+	LEAQ 24(SI), R9
+
+sse2Source:
+	CMPQ BX, CX
+	JAE  sse2Store
+
+	// PXOR requires aligned memory operands, so the block is loaded first
+	MOVQ  (R9), R8
+	MOVOU (R8)(AX*1), X4
+	MOVOU 16(R8)(AX*1), X5
+	MOVOU 32(R8)(AX*1), X6
+	MOVOU 48(R8)(AX*1), X7
+	PXOR  X4, X0
+	PXOR  X5, X1
+	PXOR  X6, X2
+	PXOR  X7, X3
+
+	ADDQ $24, R9
+	INCQ BX
+	JMP  sse2Source
+
+sse2Store:
+	MOVOU X0, (DI)(AX*1)
+	MOVOU X1, 16(DI)(AX*1)
+	MOVOU X2, 32(DI)(AX*1)
+	MOVOU X3, 48(DI)(AX*1)
+
+	ADDQ $64, AX
+	JMP  sse2Block
+
+sse2Done:
+	RET
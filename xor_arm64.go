@@ -0,0 +1,20 @@
+//go:build !purego
+
+package streammux
+
+// xorNEON sets the first n bytes of dst to the XOR of the first n bytes of
+// the nsrcs buffers at srcs. n must be a multiple of 64.
+//
+//go:noescape
+func xorNEON(dst *byte, srcs *StripeBuffer, nsrcs, n int)
+
+// xorBlocks XORs the srcs into dst in blocks of 64 bytes and returns the
+// number of bytes handled. NEON is part of every arm64 processor.
+func xorBlocks(dst StripeBuffer, srcs StripeBufferList) int {
+	n := len(dst) &^ 63
+	if n > 0 {
+		xorNEON(&dst[0], &srcs[0], len(srcs), n)
+	}
+
+	return n
+}
@@ -0,0 +1,52 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// The kernel keeps a block of the first source in registers, folds the same
+// block of every other source into it and stores the result, such that memory
+// is walked only once. The sources are slice headers of 24 bytes.
+
+// func xorNEON(dst *byte, srcs *StripeBuffer, nsrcs, n int)
+TEXT ·xorNEON(SB), NOSPLIT, $0-32
+	MOVD dst+0(FP), R0
+	MOVD srcs+8(FP), R1
+	MOVD nsrcs+16(FP), R2
+	MOVD n+24(FP), R3
+	MOVD $0, R4
+
+block:
+	CMP R3, R4
+	BGE done
+
+	MOVD (R1), R5
+	ADD  R4, R5, R5
+	VLD1 (R5), [V0.B16, V1.B16, V2.B16, V3.B16]
+
+	MOVD $1, R6
+	ADD  $24, R1, R7
+
+source:
+	CMP R2, R6
+	BGE store
+
+	MOVD (R7), R5
+	ADD  R4, R5, R5
+	VLD1 (R5), [V4.B16, V5.B16, V6.B16, V7.B16]
+	VEOR V4.B16, V0.B16, V0.B16
+	VEOR V5.B16, V1.B16, V1.B16
+	VEOR V6.B16, V2.B16, V2.B16
+	VEOR V7.B16, V3.B16, V3.B16
+
+	ADD $24, R7, R7
+	ADD $1, R6, R6
+	B   source
+
+store:
+	ADD  R4, R0, R5
+	VST1 [V0.B16, V1.B16, V2.B16, V3.B16], (R5)
+
+	ADD $64, R4, R4
+	B   block
+
+done:
+	RET
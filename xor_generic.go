@@ -0,0 +1,9 @@
+//go:build purego || !(amd64 || arm64)
+
+package streammux
+
+// xorBlocks leaves all of the buffers to xorGeneric on architectures without
+// an assembly kernel.
+func xorBlocks(dst StripeBuffer, srcs StripeBufferList) int {
+	return 0
+}
@@ -0,0 +1,99 @@
+package streammux_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/bh107/streammux"
+)
+
+// xorReference computes the XOR of srcs a byte at a time.
+func xorReference(srcs streammux.StripeBufferList) []byte {
+	dst := make([]byte, len(srcs[0]))
+	for _, src := range srcs {
+		for i := range dst {
+			dst[i] ^= src[i]
+		}
+	}
+
+	return dst
+}
+
+func TestXORInto(t *testing.T) {
+	for _, size := range []int{0, 1, 7, 8, 63, 64, 65, 127, 128, 129, 200, 4096, 4096 + 13} {
+		for nsrcs := 1; nsrcs <= 6; nsrcs++ {
+			t.Run(fmt.Sprintf("%d/%d", size, nsrcs), func(t *testing.T) {
+				// offset the buffers to exercise unaligned access
+				srcs := make(streammux.StripeBufferList, nsrcs)
+				for i := range srcs {
+					buf := make([]byte, size+i+1)
+					if _, err := rand.Read(buf); err != nil {
+						t.Fatal(err)
+					}
+
+					srcs[i] = buf[i+1:]
+				}
+
+				want := xorReference(srcs)
+
+				dst := make([]byte, size+3)
+				srcs.XORInto(dst[3:])
+
+				if !bytes.Equal(dst[3:], want) {
+					t.Fatal("XORInto differs from the byte-wise XOR")
+				}
+
+				if !bytes.Equal(srcs.XOR(), want) {
+					t.Fatal("XOR differs from the byte-wise XOR")
+				}
+
+				// the destination may be one of the sources
+				srcs.XORInto(srcs[nsrcs-1])
+
+				if !bytes.Equal(srcs[nsrcs-1], want) {
+					t.Fatal("XORInto into a source differs from the byte-wise XOR")
+				}
+			})
+		}
+	}
+}
+
+func TestXORIntoEmpty(t *testing.T) {
+	dst := make([]byte, 4096+13)
+	if _, err := rand.Read(dst); err != nil {
+		t.Fatal(err)
+	}
+
+	// the XOR of no buffers is zero
+	streammux.StripeBufferList{}.XORInto(dst)
+
+	if !bytes.Equal(dst, make([]byte, len(dst))) {
+		t.Fatal("expected XORInto of no buffers to zero dst")
+	}
+
+	if streammux.StripeBufferList(nil).XOR() != nil {
+		t.Fatal("expected XOR of no buffers to be nil")
+	}
+}
+
+func BenchmarkXORInto(b *testing.B) {
+	for _, nsrcs := range []int{2, 4, 8} {
+		b.Run(fmt.Sprintf("%d", nsrcs), func(b *testing.B) {
+			srcs := make(streammux.StripeBufferList, nsrcs)
+			for i := range srcs {
+				srcs[i] = make([]byte, 64<<10)
+			}
+
+			dst := make([]byte, 64<<10)
+
+			b.SetBytes(int64(nsrcs * len(dst)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				srcs.XORInto(dst)
+			}
+		})
+	}
+}